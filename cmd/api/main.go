@@ -3,14 +3,23 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"strings"
 	"time"
 
+	"github.com/Slade66/parallel-fetcher/internal/client"
+	"github.com/Slade66/parallel-fetcher/internal/hoststats"
+	"github.com/Slade66/parallel-fetcher/internal/ratelimit"
+	"github.com/Slade66/parallel-fetcher/internal/redact"
 	"github.com/Slade66/parallel-fetcher/internal/status"
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
+	"github.com/Slade66/parallel-fetcher/pkg/metalink"
 	"github.com/Slade66/parallel-fetcher/pkg/task"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -19,8 +28,15 @@ import (
 
 const RedisStreamName = "download_tasks"
 
+// probeTimeout 是提交任务时探测远程文件名的超时时间
+const probeTimeout = 10 * time.Second
+
 var RedisClient *redis.Client
 var statusManager *status.Manager // 新增
+var hostStats *hoststats.Store
+
+// 与 Worker 相同的传输配置，探测远程文件时使用任务选择的配置，为 nil 时使用默认的客户端
+var transportProfiles *client.Profiles
 
 // initRedis 函数保持不变
 func initRedis() {
@@ -44,12 +60,73 @@ func initRedis() {
 	fmt.Println("✅ API 成功连接到 Redis!")
 }
 
+// downloadOptions 是单个下载请求和 Metalink 中每个文件共用的下载选项
+type downloadOptions struct {
+	Threads             int    `json:"threads"`
+	MaxRetries          int    `json:"max_retries"`
+	RetryBackoffMs      int    `json:"retry_backoff_ms"`
+	RetryMaxBackoffMs   int    `json:"retry_max_backoff_ms"`
+	MinChunkSize        int64  `json:"min_chunk_size"`
+	MaxChunkSize        int64  `json:"max_chunk_size"`
+	WriteMode           string `json:"write_mode" binding:"omitempty,oneof=parts direct"`
+	TimeoutSeconds      int    `json:"timeout_seconds"`
+	StallTimeoutSeconds int    `json:"stall_timeout_seconds"`
+	ProbeSidecar        bool   `json:"probe_sidecar"`
+	RateLimit           string `json:"rate_limit"`
+	AdaptiveThreads     bool   `json:"adaptive_threads"`
+	// 请求头和凭据，探测文件信息和下载时都会使用
+	Headers     map[string]string `json:"headers"`
+	Cookies     string            `json:"cookies"`
+	BearerToken string            `json:"bearer_token"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	// Worker 上配置的传输配置名，不存在时任务会失败
+	TransportProfile string `json:"transport_profile"`
+}
+
+// newTask 根据下载选项创建下载 url 的任务，并返回校验过的请求头
+func (o *downloadOptions) newTask(url string) (*task.DownloadTask, http.Header, error) {
+	if _, err := ratelimit.ParseRate(o.RateLimit); err != nil {
+		return nil, nil, err
+	}
+	// 客户端未提供线程数时保持为 0，由 Worker 根据该主机以往的下载表现决定
+	t := &task.DownloadTask{
+		ID:                  uuid.New(),
+		URL:                 url,
+		Threads:             o.Threads,
+		MaxRetries:          o.MaxRetries,
+		RetryBackoffMs:      o.RetryBackoffMs,
+		RetryMaxBackoffMs:   o.RetryMaxBackoffMs,
+		MinChunkSize:        o.MinChunkSize,
+		MaxChunkSize:        o.MaxChunkSize,
+		WriteMode:           o.WriteMode,
+		TimeoutSeconds:      o.TimeoutSeconds,
+		StallTimeoutSeconds: o.StallTimeoutSeconds,
+		ProbeSidecar:        o.ProbeSidecar,
+		RateLimit:           o.RateLimit,
+		AdaptiveThreads:     o.AdaptiveThreads,
+		Headers:             o.Headers,
+		Cookies:             o.Cookies,
+		BearerToken:         o.BearerToken,
+		Username:            o.Username,
+		Password:            o.Password,
+		TransportProfile:    o.TransportProfile,
+	}
+	header, err := t.RequestHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, header, nil
+}
+
 // downloadHandler 处理下载请求，并初始化任务状态
 func downloadHandler(c *gin.Context) {
 	var request struct {
-		URL        string `json:"url" binding:"required"`
-		OutputPath string `json:"output_path"`
-		Threads    int    `json:"threads"`
+		URL        string   `json:"url" binding:"required"`
+		Mirrors    []string `json:"mirrors" binding:"omitempty,dive,url"`
+		OutputPath string   `json:"output_path"`
+		Checksum   string   `json:"checksum"`
+		downloadOptions
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -57,45 +134,194 @@ func downloadHandler(c *gin.Context) {
 		return
 	}
 
-	// 如果客户端未提供 OutputPath，则从 URL 自动生成
-	if request.OutputPath == "" {
-		request.OutputPath = "/app/downloads/" + path.Base(request.URL)
-	}
-	// 如果客户端未提供线程数，设置默认值
-	if request.Threads <= 0 {
-		request.Threads = 8
+	// 校验并统一期望摘要的格式
+	if request.Checksum != "" {
+		digest, err := checksum.Parse(request.Checksum)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求: " + err.Error()})
+			return
+		}
+		request.Checksum = digest.String()
 	}
 
 	// 创建任务结构体
-	task := &task.DownloadTask{
-		ID:         uuid.New(),
-		URL:        request.URL,
-		OutputPath: request.OutputPath,
-		Threads:    request.Threads,
+	task, header, err := request.newTask(request.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求: " + err.Error()})
+		return
+	}
+	task.Mirrors = request.Mirrors
+	task.OutputPath = request.OutputPath
+	task.Checksum = request.Checksum
+
+	// 如果客户端未提供 OutputPath，则使用服务器建议的文件名
+	if task.OutputPath == "" {
+		httpClient, err := probeClient(task.TransportProfile)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求: " + err.Error()})
+			return
+		}
+		task.OutputPath = "/app/downloads/" + suggestFilename(c.Request.Context(), httpClient, task.URL, header)
+	}
+
+	if err := submitTask(c.Request.Context(), task); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法将任务发布到 Redis"})
+		return
 	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "任务已成功接收，正在排队等待处理...",
+		"task_id": task.ID.String(),
+	})
+}
+
+// metalinkHandler 把 Metalink 文档中的每个文件展开为一个下载任务，任务带有镜像列表、整个文件的摘要和分块摘要
+// 请求体可以是 JSON (metalink_url 是文档的 http(s) 地址，不接受本地路径，或者 metalink 直接给出文档内容，其余字段与 /download 的下载选项相同)，
+// 也可以直接上传文档本身 (此时使用默认的下载选项，保存目录由查询参数 output_dir 指定)
+// 任何一个文件无效时都不会投递任务
+func metalinkHandler(c *gin.Context) {
+	var request struct {
+		MetalinkURL string `json:"metalink_url"`
+		Metalink    string `json:"metalink"`
+		OutputDir   string `json:"output_dir"`
+		downloadOptions
+	}
+
+	var doc *metalink.Metalink
+	var err error
+	if c.ContentType() == "application/json" {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求: " + err.Error()})
+			return
+		}
+		switch {
+		case request.MetalinkURL != "" && request.Metalink != "":
+			err = errors.New("metalink_url 和 metalink 只能提供一个")
+		case request.MetalinkURL != "":
+			var httpClient *http.Client
+			if httpClient, err = probeClient(request.TransportProfile); err != nil {
+				break
+			}
+			ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+			defer cancel()
+			doc, err = metalink.LoadURL(ctx, httpClient, request.MetalinkURL)
+		case request.Metalink != "":
+			doc, err = metalink.Parse(strings.NewReader(request.Metalink))
+		default:
+			err = errors.New("必须提供 metalink_url 或 metalink")
+		}
+	} else {
+		request.OutputDir = c.Query("output_dir")
+		doc, err = metalink.Parse(c.Request.Body)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求: " + redact.Text(err.Error())})
+		return
+	}
+	if request.OutputDir == "" {
+		request.OutputDir = "/app/downloads"
+	}
+
+	tasks := make([]*task.DownloadTask, 0, len(doc.Files))
+	for i := range doc.Files {
+		t, err := metalinkTask(&doc.Files[i], request.OutputDir, &request.downloadOptions)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求: " + err.Error()})
+			return
+		}
+		tasks = append(tasks, t)
+	}
+
+	submitted := make([]gin.H, 0, len(tasks))
+	for _, t := range tasks {
+		if err := submitTask(c.Request.Context(), t); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法将任务发布到 Redis", "tasks": submitted})
+			return
+		}
+		submitted = append(submitted, gin.H{"task_id": t.ID.String(), "output_path": t.OutputPath})
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": fmt.Sprintf("Metalink 中的 %d 个文件已成功接收，正在排队等待处理...", len(submitted)),
+		"tasks":   submitted,
+	})
+}
+
+// metalinkTask 为 Metalink 中的一个文件创建下载任务：优先级最高的地址作为 URL，其余地址作为镜像
+func metalinkTask(f *metalink.File, outputDir string, opts *downloadOptions) (*task.DownloadTask, error) {
+	urls := f.HTTPURLs()
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("文件 %s 没有 http(s) 下载地址", f.Name)
+	}
+	digest, err := f.Checksum()
+	if err != nil {
+		return nil, err
+	}
+	pieceLength, pieces, err := f.PieceChecksums()
+	if err != nil {
+		return nil, err
+	}
+	t, _, err := opts.newTask(urls[0])
+	if err != nil {
+		return nil, err
+	}
+	t.Mirrors = urls[1:]
+	t.OutputPath = path.Join(outputDir, f.Name)
+	// 不同目录下可能有同名文件，上传时保留文件的相对路径
+	t.ObjectKey = f.Name
+	t.Checksum = digest.String()
+	t.Size = f.Size
+	t.PieceLength = pieceLength
+	for _, p := range pieces {
+		t.PieceChecksums = append(t.PieceChecksums, p.String())
+	}
+	return t, nil
+}
+
+// submitTask 把任务投递到消息队列，并初始化任务状态
+func submitTask(ctx context.Context, task *task.DownloadTask) error {
 	taskJSON, _ := json.Marshal(task)
 
 	// 1. 投递任务到 Stream
-	err := RedisClient.XAdd(c.Request.Context(), &redis.XAddArgs{
+	err := RedisClient.XAdd(ctx, &redis.XAddArgs{
 		Stream: RedisStreamName,
 		Values: map[string]interface{}{"payload": taskJSON},
 	}).Err()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法将任务发布到 Redis"})
-		return
+		return err
 	}
 
 	// 2. 初始化任务状态记录
-	if err := statusManager.InitTaskStatus(c.Request.Context(), task); err != nil {
+	if err := statusManager.InitTaskStatus(ctx, task); err != nil {
 		// 这是一个非关键性错误，只记录日志
 		log.Printf("警告：无法初始化任务状态记录: %v", err)
 	}
 
 	log.Printf("📥 任务已投递到消息队列，ID: %s", task.ID)
-	c.JSON(http.StatusAccepted, gin.H{
-		"message": "任务已成功接收，正在排队等待处理...",
-		"task_id": task.ID.String(),
-	})
+	return nil
+}
+
+// probeClient 返回探测远程文件时使用的客户端，与 Worker 下载该任务时使用同一个传输配置 (代理、CA 证书、HTTP/3 等)
+// API 没有加载传输配置时使用默认的客户端
+func probeClient(profile string) (*http.Client, error) {
+	if transportProfiles == nil {
+		return client.GetClient(), nil
+	}
+	return transportProfiles.Client(profile)
+}
+
+// suggestFilename 使用 c 探测远程文件，返回服务器在 Content-Disposition 中建议的文件名或重定向后 URL 路径的最后一段
+// 探测失败时退回到原始 URL 路径的最后一段
+func suggestFilename(ctx context.Context, c *http.Client, rawURL string, header http.Header) string {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	if info, err := fileinfo.Get(ctx, rawURL, fileinfo.WithClient(c), fileinfo.WithHeader(header)); err == nil && info.Filename != "" {
+		return info.Filename
+	} else if err != nil {
+		log.Printf("⚠️ 无法探测文件信息，使用 URL 中的文件名: %s", redact.Text(err.Error()))
+	}
+	if name := fileinfo.FilenameFromURL(rawURL); name != "" {
+		return name
+	}
+	return "unknown_file"
 }
 
 // 新增：getTasksHandler 用于处理获取所有任务列表的请求
@@ -108,10 +334,44 @@ func getTasksHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, tasks)
 }
 
+// getHostsHandler 返回 Worker 学习到的每台主机在不同线程数下的表现以及推荐的线程数
+func getHostsHandler(c *gin.Context) {
+	hosts, err := hostStats.All(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法从 Redis 获取主机统计: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hosts)
+}
+
+// cancelTaskHandler 请求取消一个排队中或正在执行的任务
+func cancelTaskHandler(c *gin.Context) {
+	taskID := c.Param("id")
+	if _, err := uuid.Parse(taskID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务 ID"})
+		return
+	}
+	if err := statusManager.RequestCancel(c.Request.Context(), taskID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法取消任务: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "已请求取消任务", "task_id": taskID})
+}
+
 func main() {
 	// 初始化
 	initRedis()
 	statusManager = status.NewManager(RedisClient) // 初始化 statusManager
+	hostStats = hoststats.NewStore(RedisClient)
+
+	// 探测远程文件时需要与 Worker 使用相同的传输配置，否则需要代理或自定义证书的主机探测不到文件名
+	if v := os.Getenv("TRANSPORT_PROFILES"); v != "" {
+		var err error
+		if transportProfiles, err = client.LoadProfiles(v); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		log.Printf("✅ 已加载传输配置: %s", strings.Join(transportProfiles.Names(), ", "))
+	}
 
 	// 设置 Gin
 	router := gin.Default()
@@ -120,7 +380,10 @@ func main() {
 	api := router.Group("/api")
 	{
 		api.POST("/download", downloadHandler)
+		api.POST("/metalink", metalinkHandler)
 		api.GET("/tasks", getTasksHandler)
+		api.POST("/tasks/:id/cancel", cancelTaskHandler)
+		api.GET("/hosts", getHostsHandler)
 	}
 
 	// 2. 将所有静态文件（如css, js）的请求，都指向 frontend 目录
@@ -3,15 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Slade66/parallel-fetcher/internal/client"
 	"github.com/Slade66/parallel-fetcher/internal/downloader"
+	"github.com/Slade66/parallel-fetcher/internal/hoststats"
+	"github.com/Slade66/parallel-fetcher/internal/ratelimit"
+	"github.com/Slade66/parallel-fetcher/internal/redact"
 	"github.com/Slade66/parallel-fetcher/internal/status"
 	"github.com/Slade66/parallel-fetcher/internal/uploader"
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
 	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
 	"github.com/Slade66/parallel-fetcher/pkg/task"
 	"github.com/redis/go-redis/v9"
@@ -33,6 +42,26 @@ var (
 	RedisClient   *redis.Client
 	obsUploader   *uploader.ObsUploader
 	statusManager *status.Manager
+	hostStats     *hoststats.Store
+	// 存放分片和下载清单的目录，为空时使用系统临时目录
+	workDir string
+	// 任务未指定截止时长时使用的默认值，为 0 表示不限制
+	defaultTaskTimeout time.Duration
+	// 本 Worker 进程中所有下载共享的带宽上限，为 nil 表示不限速
+	workerLimiter ratelimit.Limiter
+	// 整个集群的带宽上限 (字节/秒)，由所有 Worker 通过 Redis 共享，为 0 表示不限速
+	clusterRate int64
+	// Redis 不可用时代替集群限速的本地限速器，速度是本 Worker 分到的份额而不是整个集群的上限
+	clusterFallback ratelimit.Limiter
+	// 下载地址过期时获取新地址的回调，为空时只重新解析重定向
+	resolverEndpoint string
+	resolverToken    string
+	// 任务可以选择的传输配置，为 nil 时所有任务都使用默认的客户端
+	transportProfiles *client.Profiles
+
+	// 正在执行的任务 ID 到其取消函数的映射
+	runningMu sync.Mutex
+	running   = make(map[string]context.CancelFunc)
 )
 
 // initRedis 初始化 Redis 连接
@@ -80,12 +109,15 @@ func processTasks(ctx context.Context) {
 	}
 	log.Printf("▶️ Worker '%s' 开始监听任务...", consumerName)
 
+	// 启动时先重新处理已投递给自己但尚未 ACK 的消息 (例如上次在下载途中崩溃)，
+	// 它们的下载进度保存在工作目录中，可以断点续传。处理完之后再接收新消息。
+	startID := "0"
 	for {
 		// 1. 从 Stream 中阻塞式地读取一个新任务
 		streams, err := RedisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    GroupName,
 			Consumer: consumerName,
-			Streams:  []string{StreamName, ">"}, // ">" 表示只接收从未被消费过的新消息
+			Streams:  []string{StreamName, startID}, // ">" 表示只接收从未被消费过的新消息
 			Count:    1,
 			Block:    0, // 阻塞直到有新消息
 		}).Result()
@@ -95,14 +127,24 @@ func processTasks(ctx context.Context) {
 			time.Sleep(5 * time.Second)
 			continue // 出错后重试
 		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			// 未 ACK 的消息已经处理完毕，开始接收新消息
+			startID = ">"
+			continue
+		}
 
 		// 2. 解析收到的消息
 		message := streams[0].Messages[0]
-		payload := message.Values["payload"].(string)
+		if startID != ">" {
+			log.Printf("🔁 重新处理未完成的任务消息: %s", message.ID)
+			startID = message.ID
+		}
+		payload, _ := message.Values["payload"].(string)
 
 		var currentTask task.DownloadTask
 		if err := json.Unmarshal([]byte(payload), &currentTask); err != nil {
-			log.Printf("‼️ 无法解析任务 payload: %v。Payload: %s", err, payload)
+			// payload 中可能包含凭据，不写入日志
+			log.Printf("‼️ 无法解析任务 payload (消息 %s): %v", message.ID, err)
 			// 解析失败的任务，我们直接 ACK 并跳过，防止阻塞队列
 			RedisClient.XAck(ctx, StreamName, GroupName, message.ID)
 			continue
@@ -110,19 +152,69 @@ func processTasks(ctx context.Context) {
 
 		log.Printf("👍 接收到新任务: [ID: %s]", currentTask.ID)
 
+		taskID := currentTask.ID.String()
+
+		// 在排队期间已被取消的任务直接跳过
+		if statusManager.IsCancelRequested(ctx, taskID) {
+			log.Printf("🛑 任务在开始前已被取消: [ID: %s]", currentTask.ID)
+			statusManager.UpdateTaskStatus(ctx, taskID, "canceled")
+			RedisClient.XAck(ctx, StreamName, GroupName, message.ID)
+			continue
+		}
+
 		// 3. 更新任务状态为 "processing"
-		statusManager.UpdateTaskStatus(ctx, currentTask.ID.String(), "processing")
+		statusManager.UpdateTaskStatus(ctx, taskID, "processing")
 
-		// 4. 执行下载和上传
-		if err := executeDownload(&currentTask); err != nil {
-			log.Printf("🔥 任务执行失败: [ID: %s], 错误: %v", currentTask.ID, err)
-			// 更新任务状态为 "failed" 并记录错误信息
-			statusManager.UpdateTaskError(ctx, currentTask.ID.String(), err.Error())
-			// 失败的任务我们不 ACK，以便后续可以重试或手动处理
+		// 4. 执行下载和上传，任务可以被取消，也可能因为超过截止时间而中止
+		var (
+			taskCtx context.Context
+			cancel  context.CancelFunc
+		)
+		if timeout := taskTimeout(&currentTask); timeout > 0 {
+			taskCtx, cancel = context.WithTimeout(ctx, timeout)
 		} else {
+			taskCtx, cancel = context.WithCancel(ctx)
+		}
+		trackTask(taskID, cancel)
+		err = executeDownload(taskCtx, &currentTask)
+		untrackTask(taskID)
+		cancel()
+
+		// 错误信息中可能包含签名 URL 或任务的凭据，记录之前先隐藏
+		var errMsg string
+		if err != nil {
+			errMsg = redact.Text(err.Error(), currentTask.Secrets()...)
+		}
+		switch {
+		case err != nil && errors.Is(err, context.Canceled):
+			log.Printf("🛑 任务已被取消: [ID: %s]", currentTask.ID)
+			statusManager.UpdateTaskStatus(ctx, taskID, "canceled")
+			// 被取消的任务不需要重试，直接 ACK
+			RedisClient.XAck(ctx, StreamName, GroupName, message.ID)
+		case err != nil && errors.As(err, new(*checksum.MismatchError)):
+			log.Printf("🔥 任务文件校验失败: [ID: %s], 错误: %s", currentTask.ID, errMsg)
+			// 校验失败单独标记，以便与普通的下载失败区分
+			statusManager.UpdateTaskFailure(ctx, taskID, "checksum_mismatch", errMsg)
+			// 重新下载得到的还是同一个文件，直接 ACK
+			RedisClient.XAck(ctx, StreamName, GroupName, message.ID)
+		case err != nil:
+			if errors.Is(err, context.DeadlineExceeded) {
+				errMsg = "任务超时: " + errMsg
+			}
+			log.Printf("🔥 任务执行失败: [ID: %s], 错误: %s", currentTask.ID, errMsg)
+			// 更新任务状态为 "failed" 并记录错误信息
+			statusManager.UpdateTaskError(ctx, taskID, errMsg)
+			// 数据已经下载完成、只是保存或上传失败的任务不 ACK，Worker 重启后从上传这一步继续；
+			// 其余失败重新执行也不会成功，ACK 之后不再重复下载
+			if downloader.Resumable(err) {
+				log.Printf("⏸️ 任务的数据已保留，Worker 重启后将继续: [ID: %s]", currentTask.ID)
+			} else {
+				RedisClient.XAck(ctx, StreamName, GroupName, message.ID)
+			}
+		default:
 			log.Printf("✅ 任务成功完成: [ID: %s]", currentTask.ID)
 			// 任务成功后，先更新状态为 "completed"
-			statusManager.UpdateTaskStatus(ctx, currentTask.ID.String(), "completed")
+			statusManager.UpdateTaskStatus(ctx, taskID, "completed")
 
 			// 然后再 ACK 消息，表示任务已被完全处理
 			if err := RedisClient.XAck(ctx, StreamName, GroupName, message.ID).Err(); err != nil {
@@ -132,28 +224,292 @@ func processTasks(ctx context.Context) {
 	}
 }
 
+// trackTask 记录正在执行的任务及其取消函数
+func trackTask(taskID string, cancel context.CancelFunc) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	running[taskID] = cancel
+}
+
+// untrackTask 在任务结束后移除记录
+func untrackTask(taskID string) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	delete(running, taskID)
+}
+
+// listenCancellations 监听取消请求，取消本 Worker 上正在执行的对应任务
+func listenCancellations(ctx context.Context) {
+	for taskID := range statusManager.CancelRequests(ctx) {
+		runningMu.Lock()
+		cancel, ok := running[taskID]
+		runningMu.Unlock()
+		if ok {
+			log.Printf("🛑 收到取消请求，正在停止任务: [ID: %s]", taskID)
+			cancel()
+		}
+	}
+}
+
+// taskTimeout 返回任务的截止时长：优先使用任务自身的设置，否则使用 Worker 的默认值
+func taskTimeout(t *task.DownloadTask) time.Duration {
+	if t.TimeoutSeconds > 0 {
+		return time.Duration(t.TimeoutSeconds) * time.Second
+	}
+	return defaultTaskTimeout
+}
+
 // executeDownload 负责调用下载器来执行单个下载任务
-func executeDownload(t *task.DownloadTask) error {
-	log.Printf("🔎 正在获取文件信息: %s", t.URL)
-	info, err := fileinfo.Get(t.URL)
+func executeDownload(ctx context.Context, t *task.DownloadTask) error {
+	var expected checksum.Digest
+	if t.Checksum != "" {
+		var err error
+		if expected, err = checksum.Parse(t.Checksum); err != nil {
+			return err
+		}
+	}
+
+	taskRate, err := ratelimit.ParseRate(t.RateLimit)
+	if err != nil {
+		return err
+	}
+
+	header, err := t.RequestHeader()
+	if err != nil {
+		return err
+	}
+	httpClient, err := transportProfiles.Client(t.TransportProfile)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("🔎 正在获取文件信息: %s", redact.URL(t.URL))
+	info, err := fileinfo.Get(ctx, t.URL, fileinfo.WithClient(httpClient), fileinfo.WithHeader(header))
 	if err != nil {
 		return fmt.Errorf("获取文件信息失败: %w", err)
 	}
+	if t.Size > 0 && info.Size >= 0 && info.Size != t.Size {
+		return fmt.Errorf("远程文件大小 %d 与期望的 %d 不一致", info.Size, t.Size)
+	}
+	if info.Size >= 0 {
+		statusManager.UpdateTaskSize(ctx, t.ID.String(), info.Size)
+	}
+	if len(info.Redirects) > 0 {
+		log.Printf("↪️ 任务 %s 的 URL 经过 %d 次重定向，所有分片将直接请求: %s", t.ID, len(info.Redirects)-1, redact.URL(info.FinalURL))
+		if err := statusManager.UpdateTaskRedirects(ctx, t.ID.String(), info.Redirects); err != nil {
+			log.Printf("⚠️ 无法记录任务 %s 的重定向链: %v", t.ID, err)
+		}
+	}
+
+	if expected.IsZero() {
+		discoverChecksum(ctx, t, info, fileinfo.WithClient(httpClient), fileinfo.WithHeader(header))
+	}
 
+	host := hoststats.HostOf(t.URL)
 	actualThreads := t.Threads
-	if actualThreads <= 0 {
-		actualThreads = DefaultThreads
+	if actualThreads <= 0 && t.AdaptiveThreads {
+		actualThreads = MaxAllowedThreads
+	} else if actualThreads <= 0 {
+		// 任务没有指定线程数时，使用该主机以往表现最好的线程数
+		actualThreads, err = hostStats.Suggest(ctx, host, DefaultThreads, MaxAllowedThreads)
+		if err != nil {
+			log.Printf("⚠️ 无法读取主机 %s 的统计数据，使用默认线程数: %v", host, err)
+		} else if actualThreads != DefaultThreads {
+			log.Printf("📈 根据主机 %s 以往的表现使用 %d 个线程", host, actualThreads)
+		}
 	} else if actualThreads > MaxAllowedThreads {
 		log.Printf("警告: 任务 %s 请求的线程数 (%d) 超过最大限制 (%d)，已调整。", t.ID, t.Threads, MaxAllowedThreads)
 		actualThreads = MaxAllowedThreads
 	}
 
-	log.Printf("🚀 准备下载. URL: %s, OBS对象键: %s, 线程数: %d", t.URL, t.OutputPath, actualThreads)
+	log.Printf("🚀 准备下载. URL: %s, OBS对象键: %s, 线程数: %d", redact.URL(t.URL), t.OutputPath, actualThreads)
 
 	// 创建下载器实例时，传入 obsUploader
-	d := downloader.New(t.URL, t.OutputPath, actualThreads, info.Size, info.AcceptsRanges, obsUploader)
+	d := downloader.New(t.URL, t.OutputPath, actualThreads, info, obsUploader)
+	d.SetObjectKey(t.ObjectKey)
+	d.SetRetryPolicy(retryPolicyFor(t))
+	d.SetAdaptive(t.AdaptiveThreads)
+	d.SetHeader(header)
+	d.SetClient(httpClient)
+	d.AddObserver(&concurrencyRecorder{ctx: ctx, taskID: t.ID.String()})
+	if t.MinChunkSize > 0 || t.MaxChunkSize > 0 {
+		minChunk, maxChunk := downloader.DefaultMinChunkSize, downloader.DefaultMaxChunkSize
+		if t.MinChunkSize > 0 {
+			minChunk = t.MinChunkSize
+		}
+		if t.MaxChunkSize > 0 {
+			maxChunk = t.MaxChunkSize
+		}
+		d.SetChunkSize(minChunk, maxChunk)
+	}
+	if workDir != "" {
+		d.SetWorkDir(workDir)
+	}
+	if t.WriteMode != "" {
+		d.SetWriteMode(downloader.WriteMode(t.WriteMode))
+	}
+	if !expected.IsZero() {
+		d.SetExpectedChecksum(expected)
+	}
+	d.SetProbeSidecar(t.ProbeSidecar)
+	pieceLength, pieces, err := t.Pieces()
+	if err != nil {
+		return err
+	}
+	if len(pieces) > 0 {
+		if err := d.SetPieceChecksums(pieceLength, pieces); err != nil {
+			return err
+		}
+	}
+	addMirrors(ctx, d, t, httpClient)
+	d.SetRateLimit(taskRate)
+	shared := workerLimiter
+	if clusterRate > 0 {
+		clusterLimiter := ratelimit.NewRedisBucket(RedisClient, t.ID.String(), clusterRate, clusterFallback)
+		defer clusterLimiter.Close()
+		shared = ratelimit.Join(workerLimiter, clusterLimiter)
+	}
+	d.SetSharedLimiter(shared)
+	if resolverEndpoint != "" {
+		d.SetURLResolver(&callbackResolver{endpoint: resolverEndpoint, token: resolverToken, taskID: t.ID.String()})
+	}
+	if t.StallTimeoutSeconds > 0 {
+		d.SetStallTimeout(time.Duration(t.StallTimeoutSeconds) * time.Second)
+	} else if t.StallTimeoutSeconds < 0 {
+		d.SetStallTimeout(0)
+	}
 
-	return d.Run()
+	if err := d.Run(ctx); err != nil {
+		// 不会再重新执行的任务不需要保留工作目录中的分片和清单
+		if !downloader.Resumable(err) {
+			d.Discard()
+		}
+		return err
+	}
+
+	// 大小未知的文件下载完成后才知道大小，远程文件在下载过程中变化时大小也可能不同
+	if d.Size() != info.Size {
+		statusManager.UpdateTaskSize(ctx, t.ID.String(), d.Size())
+	}
+
+	// 记录本次下载的表现，供后续下载同一主机上的文件时选择线程数
+	// 使用了镜像时吞吐量来自多台主机，不代表该主机的表现
+	if len(t.Mirrors) > 0 {
+		return nil
+	}
+	stats := d.Stats()
+	sample := hoststats.Sample{
+		Threads:  stats.Threads,
+		Bytes:    stats.Bytes,
+		Duration: stats.Duration,
+		Requests: stats.Requests,
+		Errors:   stats.Errors,
+	}
+	if err := hostStats.Record(ctx, host, sample); err != nil {
+		log.Printf("⚠️ 无法记录主机 %s 的统计数据: %v", host, err)
+	}
+	return nil
+}
+
+// addMirrors 探测任务中的每个镜像，把与原始 URL 一致的镜像交给下载器
+// 无法访问或内容不一致的镜像只记录日志，不影响从原始 URL 下载
+// 镜像可能在其他主机上，探测时与下载时一样不向它发送凭据
+func addMirrors(ctx context.Context, d *downloader.Downloader, t *task.DownloadTask, c *http.Client) {
+	for _, m := range t.Mirrors {
+		info, err := fileinfo.Get(ctx, m, fileinfo.WithClient(c), fileinfo.WithHeader(d.HeaderFor(m)))
+		if err == nil {
+			err = d.AddMirror(m, info)
+		}
+		if err != nil {
+			log.Printf("⚠️ 任务 %s 不使用镜像 %s: %s", t.ID, redact.URL(m), redact.Text(err.Error(), t.Secrets()...))
+			continue
+		}
+		log.Printf("🪞 任务 %s 使用镜像: %s", t.ID, redact.URL(m))
+	}
+}
+
+// discoverChecksum 在任务没有提供期望摘要时，使用服务器响应头中的摘要，
+// 或者在任务允许时探测摘要文件。找到的摘要会记录到任务状态中，并由下载器在下载完成后校验
+func discoverChecksum(ctx context.Context, t *task.DownloadTask, info *fileinfo.Info, opts ...fileinfo.Option) {
+	if info.Checksum.IsZero() && t.ProbeSidecar {
+		digest, source, err := fileinfo.FindSidecarChecksum(ctx, t.URL, opts...)
+		if err != nil {
+			log.Printf("ℹ️ 任务 %s 未找到摘要文件: %s", t.ID, redact.Text(err.Error(), t.Secrets()...))
+			return
+		}
+		info.Checksum, info.ChecksumSource = digest, source
+	}
+	if info.Checksum.IsZero() {
+		return
+	}
+	log.Printf("🔐 任务 %s 将使用 %s 提供的摘要进行校验: %s", t.ID, redact.URL(info.ChecksumSource), info.Checksum)
+	if err := statusManager.UpdateTaskChecksum(ctx, t.ID.String(), info.Checksum.String(), info.ChecksumSource); err != nil {
+		log.Printf("⚠️ 无法记录任务 %s 的摘要: %v", t.ID, err)
+	}
+}
+
+// concurrencyRecorder 把下载使用的连接数以及重新下载后的文件大小记录到任务状态中
+type concurrencyRecorder struct {
+	ctx    context.Context
+	taskID string
+}
+
+// Update 实现 observer.Observer 接口，下载进度不需要记录
+func (r *concurrencyRecorder) Update(int64) {}
+
+// UpdateConcurrency 实现 observer.ConcurrencyObserver 接口
+func (r *concurrencyRecorder) UpdateConcurrency(connections int) {
+	if err := statusManager.UpdateTaskConcurrency(r.ctx, r.taskID, connections); err != nil {
+		log.Printf("⚠️ 无法记录任务 %s 的连接数: %v", r.taskID, err)
+	}
+}
+
+// Restart 实现 observer.RestartObserver 接口，远程文件变化后记录新版本的大小
+func (r *concurrencyRecorder) Restart(totalSize int64) {
+	if totalSize < 0 {
+		return
+	}
+	if err := statusManager.UpdateTaskSize(r.ctx, r.taskID, totalSize); err != nil {
+		log.Printf("⚠️ 无法记录任务 %s 的文件大小: %v", r.taskID, err)
+	}
+}
+
+// clusterFallbackRate 返回 Redis 不可用时本 Worker 的本地限速 (字节/秒)，0 表示不限速
+// fallback 是显式指定的每个 Worker 的上限；没有指定时把集群上限 total 平分给 workers 个 Worker
+// 两者都没有设置时返回错误：每个 Worker 都使用集群上限的话，整个集群的速度会达到上限的 Worker 数倍
+func clusterFallbackRate(total int64, fallback, workers string) (int64, error) {
+	if fallback != "" {
+		rate, err := ratelimit.ParseRate(fallback)
+		if err != nil {
+			return 0, fmt.Errorf("无效的 CLUSTER_RATE_FALLBACK: %w", err)
+		}
+		return rate, nil
+	}
+	if workers == "" {
+		return 0, errors.New("设置了 CLUSTER_RATE_LIMIT 时，必须通过 CLUSTER_RATE_FALLBACK 或 CLUSTER_WORKERS 指定 Redis 不可用时每个 Worker 的带宽上限")
+	}
+	n, err := strconv.Atoi(workers)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("无效的 CLUSTER_WORKERS: %q", workers)
+	}
+	return max(total/int64(n), 1), nil
+}
+
+// retryPolicyFor 根据任务中的重试配置生成下载器的重试策略，未设置的字段使用默认值
+func retryPolicyFor(t *task.DownloadTask) downloader.RetryPolicy {
+	policy := downloader.DefaultRetryPolicy()
+	if t.MaxRetries > 0 {
+		policy.MaxRetries = t.MaxRetries
+	} else if t.MaxRetries < 0 {
+		policy.MaxRetries = 0
+	}
+	if t.RetryBackoffMs > 0 {
+		policy.BaseDelay = time.Duration(t.RetryBackoffMs) * time.Millisecond
+	}
+	if t.RetryMaxBackoffMs > 0 {
+		policy.MaxDelay = time.Duration(t.RetryMaxBackoffMs) * time.Millisecond
+	}
+	return policy
 }
 
 // main 是程序的总入口
@@ -180,13 +536,70 @@ func main() {
 	defer obsUploader.Close() // 确保程序退出时关闭客户端
 	log.Println("✅ OBS Uploader 初始化成功。")
 
+	workDir = os.Getenv("WORK_DIR")
+	if v := os.Getenv("TASK_TIMEOUT"); v != "" {
+		if defaultTaskTimeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("❌ 无效的 TASK_TIMEOUT: %v", err)
+		}
+	}
+
+	if v := os.Getenv("WORKER_RATE_LIMIT"); v != "" {
+		rate, err := ratelimit.ParseRate(v)
+		if err != nil {
+			log.Fatalf("❌ 无效的 WORKER_RATE_LIMIT: %v", err)
+		}
+		if rate > 0 {
+			workerLimiter = ratelimit.NewBucket(rate)
+			log.Printf("✅ Worker 带宽上限: %s/s", v)
+		}
+	}
+
+	// 集群带宽上限在所有活跃任务之间平分；Redis 不可用时，本 Worker 上的任务共用一个本地限速器
+	// 每个 Worker 都会各自使用这个本地限速，它的速度不能是整个集群的上限：
+	// 由 CLUSTER_RATE_FALLBACK 直接指定每个 Worker 的上限，或者通过 CLUSTER_WORKERS 把集群上限平分给各个 Worker
+	if v := os.Getenv("CLUSTER_RATE_LIMIT"); v != "" {
+		if clusterRate, err = ratelimit.ParseRate(v); err != nil {
+			log.Fatalf("❌ 无效的 CLUSTER_RATE_LIMIT: %v", err)
+		}
+		if clusterRate > 0 {
+			fallbackRate, err := clusterFallbackRate(clusterRate, os.Getenv("CLUSTER_RATE_FALLBACK"), os.Getenv("CLUSTER_WORKERS"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			log.Printf("✅ 集群带宽上限: %s/s", v)
+			if fallbackRate > 0 {
+				clusterFallback = ratelimit.NewBucket(fallbackRate)
+				log.Printf("✅ Redis 不可用时本 Worker 的带宽上限: %.2f MB/s", float64(fallbackRate)/1024/1024)
+			}
+		}
+	}
+
+	// 代理、CA 证书等传输配置，任务通过 transport_profile 选择
+	if v := os.Getenv("TRANSPORT_PROFILES"); v != "" {
+		if transportProfiles, err = client.LoadProfiles(v); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		log.Printf("✅ 已加载传输配置: %s", strings.Join(transportProfiles.Names(), ", "))
+	}
+
+	// 预签名 URL 的有效期可能比下载时间还短，过期后通过回调获取新的 URL
+	resolverEndpoint = os.Getenv("URL_RESOLVER_ENDPOINT")
+	resolverToken = os.Getenv("URL_RESOLVER_TOKEN")
+	if resolverEndpoint != "" {
+		log.Printf("✅ 下载地址过期时将通过回调刷新: %s", resolverEndpoint)
+	}
+
 	// 初始化 Status Manager
 	statusManager = status.NewManager(RedisClient)
+	hostStats = hoststats.NewStore(RedisClient)
 	log.Println("✅ Status Manager 初始化成功。")
 
 	// 确保消费者组存在
 	ensureConsumerGroup(ctx)
 
+	// 在后台监听任务取消请求
+	go listenCancellations(ctx)
+
 	// 启动主处理循环，开始工作
 	processTasks(ctx)
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Slade66/parallel-fetcher/internal/client"
+)
+
+// resolverTimeout 是单次调用 URL 刷新回调的超时时间
+const resolverTimeout = 30 * time.Second
+
+// callbackResolver 通过 HTTP 回调为任务获取新的下载地址，实现 downloader.URLResolver
+// 回调收到 POST {"task_id": "...", "url": "<提交任务时的 URL>"}，应返回 {"url": "<新的 URL>"}
+type callbackResolver struct {
+	endpoint string
+	token    string // 不为空时以 Bearer 令牌的形式发送
+	taskID   string
+}
+
+// Resolve 实现 downloader.URLResolver 接口
+func (r *callbackResolver) Resolve(ctx context.Context, rawURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolverTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{"task_id": r.taskID, "url": rawURL})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("无法创建回调请求: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := client.GetClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("调用 URL 刷新回调失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("URL 刷新回调返回了 %s", resp.Status)
+	}
+	var result struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return "", fmt.Errorf("无法解析 URL 刷新回调的响应: %w", err)
+	}
+	if result.URL == "" {
+		return "", fmt.Errorf("URL 刷新回调没有返回新的 URL")
+	}
+	return result.URL, nil
+}
@@ -23,8 +23,8 @@ document.addEventListener('DOMContentLoaded', () => {
 
         const taskData = {
             url: url,
-            output_path: outputPath, // 使用从 URL 提取的文件名作为 output_path
-            threads: 8 // 可以使用默认值
+            output_path: outputPath // 使用从 URL 提取的文件名作为 output_path
+            // 不指定 threads，由 Worker 根据该主机以往的下载表现选择线程数
         };
 
         try {
@@ -0,0 +1,200 @@
+// internal/client/config.go
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProxyDirect 作为 Config.Proxy 时表示不使用代理，即使设置了 HTTP_PROXY 等环境变量
+const ProxyDirect = "direct"
+
+// Config 描述了如何构造下载使用的 http.Client
+type Config struct {
+	// Proxy 是代理的 URL，支持 http://、https:// 和 socks5://
+	// 为空时使用 HTTP_PROXY / HTTPS_PROXY / NO_PROXY 环境变量，为 "direct" 时不使用代理
+	Proxy string `json:"proxy,omitempty"`
+	// CAFile 是额外信任的 CA 证书 (PEM) 的路径，会与系统的根证书一起使用
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile 和 KeyFile 是 mTLS 使用的客户端证书和私钥 (PEM) 的路径
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// InsecureSkipVerifyHosts 中的主机不校验服务器证书，只应用于内部测试环境
+	InsecureSkipVerifyHosts []string `json:"insecure_skip_verify_hosts,omitempty"`
+	// DisableHTTP2 为 true 时只使用 HTTP/1.1
+	// 多个分片经过 HTTP/2 时会复用同一个 TCP 连接，对单连接限速的服务器无法提高速度
+	DisableHTTP2 bool `json:"disable_http2,omitempty"`
+	// 连接池的大小，为 0 时使用默认值
+	MaxIdleConns        int `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+	// MaxConnsPerHost 限制同一主机的连接总数，为 0 表示不限制
+	MaxConnsPerHost int `json:"max_conns_per_host,omitempty"`
+	// HTTP3 是使用 HTTP/3 (QUIC) 的方式: "" (不使用)、"auto" (根据 Alt-Svc 自动发现) 或 "always"
+	// HTTP/3 不可用时自动退回 HTTP/1.1 或 HTTP/2
+	HTTP3 string `json:"http3,omitempty"`
+	// Hosts 是静态的主机名到 IP 的映射，其中的主机不查询 DNS
+	Hosts map[string][]string `json:"hosts,omitempty"`
+	// SpreadAddrs 为 true 时，同一主机的各个连接轮流使用解析出的不同 IP，让分片分散到多台服务器上
+	// 使用 HTTP/2 时所有分片共用一个连接，需要同时设置 DisableHTTP2 才能分散
+	SpreadAddrs bool `json:"spread_addrs,omitempty"`
+	// LocalAddrs 是连接使用的本地源地址 (IP 或网卡名)，各个连接轮流绑定，用于聚合多块网卡的带宽
+	LocalAddrs []string `json:"local_addrs,omitempty"`
+}
+
+// DefaultConfig 返回 GetClient 使用的配置
+func DefaultConfig() Config {
+	return Config{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+	}
+}
+
+// New 根据配置创建一个新的 http.Client
+// 与 GetClient 一样不设置 http.Client.Timeout，只设置各个阶段的超时
+func New(cfg Config) (*http.Client, error) {
+	d, err := newDialer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     !cfg.DisableHTTP2,
+		TLSHandshakeTimeout:   TLSHandshakeTimeout,
+		ResponseHeaderTimeout: ResponseHeaderTimeout,
+		IdleConnTimeout:       IdleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+	}
+	if d != nil {
+		transport.DialContext = d.DialContext
+	}
+	defaults := DefaultConfig()
+	if transport.MaxIdleConns == 0 {
+		transport.MaxIdleConns = defaults.MaxIdleConns
+	}
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+
+	switch cfg.Proxy {
+	case "":
+	case ProxyDirect:
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("无效的代理地址: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("不支持的代理协议: %q", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	switch cfg.HTTP3 {
+	case HTTP3Off, HTTP3Auto, HTTP3Always:
+	default:
+		return nil, fmt.Errorf("无效的 HTTP/3 模式: %q", cfg.HTTP3)
+	}
+
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	if cfg.DisableHTTP2 {
+		// 非 nil 的空映射会关闭 Transport 自带的 HTTP/2 支持
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	var rt http.RoundTripper = transport
+	hosts := make(map[string]bool, len(cfg.InsecureSkipVerifyHosts))
+	if len(cfg.InsecureSkipVerifyHosts) > 0 {
+		// tls.Config 只能整体跳过校验，因此发往这些主机的请求使用另一个跳过校验的 Transport
+		insecure := transport.Clone()
+		if insecure.TLSClientConfig == nil {
+			insecure.TLSClientConfig = &tls.Config{}
+		}
+		insecure.TLSClientConfig.InsecureSkipVerify = true
+		for _, h := range cfg.InsecureSkipVerifyHosts {
+			hosts[strings.ToLower(h)] = true
+		}
+		rt = &hostTransport{Transport: transport, insecure: insecure, hosts: hosts}
+	}
+	if cfg.HTTP3 != HTTP3Off {
+		rt = newH3Transport(rt, cfg.HTTP3, tlsConfig, transport.Proxy, hosts, d)
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// hostTransport 把发往 hosts 中主机的请求交给不校验证书的 insecure，其余请求交给内嵌的 Transport
+type hostTransport struct {
+	*http.Transport
+	insecure *http.Transport
+	hosts    map[string]bool
+}
+
+// RoundTrip 实现 http.RoundTripper 接口
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.hosts[strings.ToLower(req.URL.Hostname())] {
+		return t.insecure.RoundTrip(req)
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// CloseIdleConnections 关闭两个 Transport 中的空闲连接
+func (t *hostTransport) CloseIdleConnections() {
+	t.Transport.CloseIdleConnections()
+	t.insecure.CloseIdleConnections()
+}
+
+// tlsConfig 根据 CA 和客户端证书生成 TLS 配置，都没有设置时返回 nil
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && cfg.KeyFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取 CA 证书: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s 中没有有效的 PEM 证书", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("客户端证书和私钥必须同时指定")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("无法加载客户端证书: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
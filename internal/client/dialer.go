@@ -0,0 +1,174 @@
+// internal/client/dialer.go
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialer 建立 TCP 连接时使用自定义的解析和源地址:
+//   - hosts 中的主机直接使用配置的 IP，不查询 DNS
+//   - spread 为 true 时，同一主机的连续连接轮流使用解析出的各个 IP
+//   - locals 不为空时，连接轮流绑定到其中与目标 IP 地址族相同的本地地址
+//
+// 只替换建立连接的地址，TLS 的 SNI 和 Host 请求头仍然使用 URL 中的主机名
+// 某个 IP 连接失败时依次尝试其余的 IP
+type dialer struct {
+	hosts  map[string][]net.IP
+	spread bool
+	locals []net.IP
+
+	mu        sync.Mutex
+	nextHost  map[string]int // 主机到下一次使用的 IP 的序号
+	nextLocal int
+}
+
+// newDialer 根据配置创建 dialer，不需要自定义解析和源地址时返回 nil
+func newDialer(cfg Config) (*dialer, error) {
+	if len(cfg.Hosts) == 0 && !cfg.SpreadAddrs && len(cfg.LocalAddrs) == 0 {
+		return nil, nil
+	}
+	d := &dialer{
+		hosts:    make(map[string][]net.IP, len(cfg.Hosts)),
+		spread:   cfg.SpreadAddrs,
+		nextHost: make(map[string]int),
+	}
+	for host, addrs := range cfg.Hosts {
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("主机 %s 没有配置 IP", host)
+		}
+		for _, a := range addrs {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, fmt.Errorf("主机 %s 的地址 %q 不是有效的 IP", host, a)
+			}
+			d.hosts[strings.ToLower(host)] = append(d.hosts[strings.ToLower(host)], ip)
+		}
+	}
+	for _, a := range cfg.LocalAddrs {
+		ips, err := localIPs(a)
+		if err != nil {
+			return nil, err
+		}
+		d.locals = append(d.locals, ips...)
+	}
+	return d, nil
+}
+
+// localIPs 把本地地址解析为 IP，a 可以是 IP，也可以是网卡名 (使用该网卡上的所有单播地址)
+func localIPs(a string) ([]net.IP, error) {
+	if ip := net.ParseIP(a); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	iface, err := net.InterfaceByName(a)
+	if err != nil {
+		return nil, fmt.Errorf("本地地址 %q 既不是 IP 也不是网卡名: %w", a, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("无法读取网卡 %s 的地址: %w", a, err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("网卡 %s 上没有可用的地址", a)
+	}
+	return ips, nil
+}
+
+// DialContext 实现 http.Transport.DialContext
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, ip := range ips {
+		nd := &net.Dialer{Timeout: DialTimeout, KeepAlive: 30 * time.Second}
+		if len(d.locals) > 0 {
+			local := d.local(ip)
+			if local == nil {
+				errs = append(errs, fmt.Errorf("没有与 %s 地址族相同的本地地址", ip))
+				continue
+			}
+			nd.LocalAddr = &net.TCPAddr{IP: local}
+		}
+		conn, err := nd.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// lookup 返回 host 的 IP，spread 为 true 时每次调用从下一个 IP 开始
+func (d *dialer) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	host = strings.ToLower(host)
+	ips, ok := d.hosts[host]
+	if !ok {
+		var err error
+		if ips, err = lookupIPs(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	if !d.spread || len(ips) < 2 {
+		return ips, nil
+	}
+
+	d.mu.Lock()
+	start := d.nextHost[host] % len(ips)
+	d.nextHost[host] = start + 1
+	d.mu.Unlock()
+	rotated := make([]net.IP, 0, len(ips))
+	rotated = append(rotated, ips[start:]...)
+	return append(rotated, ips[:start]...), nil
+}
+
+// lookupIPs 通过 DNS 解析 host，host 本身是 IP 时直接返回
+func lookupIPs(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// local 返回下一个与 ip 地址族相同的本地地址，没有时返回 nil
+func (d *dialer) local(ip net.IP) net.IP {
+	v4 := ip.To4() != nil
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.locals {
+		l := d.locals[(d.nextLocal+i)%len(d.locals)]
+		if (l.To4() != nil) == v4 {
+			d.nextLocal = (d.nextLocal + i + 1) % len(d.locals)
+			return l
+		}
+	}
+	return nil
+}
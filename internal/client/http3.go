@@ -0,0 +1,259 @@
+// internal/client/http3.go
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Config.HTTP3 的取值
+const (
+	// HTTP3Off 不使用 HTTP/3 (默认)
+	HTTP3Off = ""
+	// HTTP3Auto 先通过 HTTP/1.1 或 HTTP/2 请求，服务器在 Alt-Svc 响应头中声明支持 h3 后，
+	// 之后发往该主机的请求改用 HTTP/3
+	HTTP3Auto = "auto"
+	// HTTP3Always 对所有 https 请求都先尝试 HTTP/3
+	HTTP3Always = "always"
+)
+
+const (
+	// altSvcDefaultMaxAge 是 Alt-Svc 没有 ma 参数时的有效期 (RFC 7838)
+	altSvcDefaultMaxAge = 24 * time.Hour
+	// h3BrokenTimeout 是 HTTP/3 请求失败后，该主机改用 HTTP/1.1 或 HTTP/2 的时长
+	h3BrokenTimeout = 5 * time.Minute
+)
+
+// h3Transport 在 HTTP/3 可用时通过 QUIC 发送请求，否则交给 base
+// HTTP/3 请求在收到响应之前失败 (例如 UDP 被防火墙拦截) 时，自动改用 base 重新发送，
+// 并在 h3BrokenTimeout 内不再对该主机尝试 HTTP/3
+// 经过代理的请求和跳过证书校验的主机不使用 HTTP/3
+type h3Transport struct {
+	base   http.RoundTripper
+	h3     *http3.Transport
+	always bool
+	proxy  func(*http.Request) (*url.URL, error)
+	skip   map[string]bool
+	dialer *dialer // 不为 nil 时用它解析 h3 服务的地址
+
+	mu     sync.Mutex
+	alts   map[string]altService // 源站 (host:port) 到 Alt-Svc 声明的 h3 地址
+	broken map[string]time.Time  // 源站到不再尝试 HTTP/3 的截止时间
+}
+
+// altService 是 Alt-Svc 声明的一个 h3 备用地址
+type altService struct {
+	addr    string
+	expires time.Time
+}
+
+// newH3Transport 创建一个 h3Transport，tlsConfig 为 nil 时使用默认的 TLS 配置
+// d 的静态主机映射和多 IP 轮换同样用于 QUIC 连接，本地源地址只用于 TCP 连接
+func newH3Transport(base http.RoundTripper, mode string, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error), skip map[string]bool, d *dialer) *h3Transport {
+	t := &h3Transport{
+		base:   base,
+		always: mode == HTTP3Always,
+		proxy:  proxy,
+		skip:   skip,
+		dialer: d,
+		alts:   make(map[string]altService),
+		broken: make(map[string]time.Time),
+	}
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+	}
+	t.h3 = &http3.Transport{
+		TLSClientConfig: tlsConfig,
+		Dial:            t.dial,
+	}
+	return t
+}
+
+// RoundTrip 实现 http.RoundTripper 接口
+func (t *h3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := originOf(req.URL)
+	if t.useH3(req, origin) {
+		resp, err := t.h3.RoundTrip(req)
+		if err == nil {
+			return resp, nil
+		}
+		if req.Context().Err() != nil || !replayable(req) {
+			return nil, err
+		}
+		t.markBroken(origin)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && req.URL.Scheme == "https" {
+		t.learn(origin, resp.Header.Values("Alt-Svc"))
+	}
+	return resp, err
+}
+
+// CloseIdleConnections 关闭两种连接中的空闲连接
+func (t *h3Transport) CloseIdleConnections() {
+	if ci, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+	t.h3.CloseIdleConnections()
+}
+
+// useH3 判断请求是否应该通过 HTTP/3 发送
+func (t *h3Transport) useH3(req *http.Request, origin string) bool {
+	if req.URL.Scheme != "https" || t.skip[strings.ToLower(req.URL.Hostname())] {
+		return false
+	}
+	if t.proxy != nil {
+		if u, err := t.proxy(req); err != nil || u != nil {
+			return false
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if until, ok := t.broken[origin]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(t.broken, origin)
+	}
+	if t.always {
+		return true
+	}
+	alt, ok := t.alts[origin]
+	if ok && now.After(alt.expires) {
+		delete(t.alts, origin)
+		return false
+	}
+	return ok
+}
+
+// dial 建立到源站 origin (URL 中的 host:port) 的 HTTP/3 服务的 QUIC 连接
+// Alt-Svc 可能把 h3 服务放在另一个端口或主机上；依次尝试解析出的每个 IP，
+// 不会因为其中一个 IP 不可达就认为该源站不支持 HTTP/3
+func (t *h3Transport) dial(ctx context.Context, origin string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+	addr := origin
+	t.mu.Lock()
+	if alt, ok := t.alts[origin]; ok {
+		addr = alt.addr
+	}
+	t.mu.Unlock()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	if t.dialer != nil {
+		ips, err = t.dialer.lookup(ctx, host)
+	} else {
+		ips, err = lookupIPs(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, ip := range ips {
+		conn, err := quic.DialAddrEarly(ctx, net.JoinHostPort(ip.String(), port), tlsCfg, cfg)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// markBroken 在一段时间内不再对 origin 尝试 HTTP/3
+func (t *h3Transport) markBroken(origin string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.broken[origin] = time.Now().Add(h3BrokenTimeout)
+}
+
+// learn 记录 Alt-Svc 响应头中声明的 h3 地址
+func (t *h3Transport) learn(origin string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	host, _, _ := net.SplitHostPort(origin)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, v := range values {
+		authority, maxAge, clear, ok := parseAltSvc(v)
+		switch {
+		case clear:
+			delete(t.alts, origin)
+		case ok:
+			altHost, port, err := net.SplitHostPort(authority)
+			if err != nil {
+				continue
+			}
+			if altHost == "" {
+				altHost = host
+			}
+			t.alts[origin] = altService{addr: net.JoinHostPort(altHost, port), expires: time.Now().Add(maxAge)}
+		}
+	}
+}
+
+// parseAltSvc 从 Alt-Svc 响应头中取出第一个 h3 备用服务的地址和有效期，
+// 例如 `h3=":443"; ma=86400, h3-29=":443"` 返回 ":443" 和 24 小时；值为 "clear" 时 clear 为 true
+func parseAltSvc(v string) (authority string, maxAge time.Duration, clear, ok bool) {
+	v = strings.TrimSpace(v)
+	if v == "clear" {
+		return "", 0, true, false
+	}
+	for _, entry := range strings.Split(v, ",") {
+		params := strings.Split(entry, ";")
+		proto, value, found := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !found || proto != "h3" {
+			continue
+		}
+		authority, err := strconv.Unquote(value)
+		if err != nil {
+			continue
+		}
+		maxAge := altSvcDefaultMaxAge
+		for _, p := range params[1:] {
+			if k, v, _ := strings.Cut(strings.TrimSpace(p), "="); k == "ma" {
+				if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+					maxAge = time.Duration(secs) * time.Second
+				}
+			}
+		}
+		return authority, maxAge, false, true
+	}
+	return "", 0, false, false
+}
+
+// originOf 返回 URL 的 host:port，没有端口时使用协议的默认端口
+func originOf(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// replayable 判断请求失败后能否再次发送，下载和探测使用的 GET/HEAD 请求没有请求体，总是可以重发
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
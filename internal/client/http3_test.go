@@ -0,0 +1,203 @@
+// internal/client/http3_test.go
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const testBody = "parallel-fetcher over h3"
+
+// newTestCert 生成 localhost 和 127.0.0.1 的自签名证书，返回证书和信任它的证书池
+func newTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// startServers 在本机启动使用同一证书的 HTTPS (TCP) 服务和 HTTP/3 (UDP) 服务，
+// HTTPS 服务在 Alt-Svc 中声明 HTTP/3 服务的端口；返回 HTTPS 服务的 URL、HTTP/3 服务的端口和证书池
+func startServers(t *testing.T) (string, int, *x509.CertPool) {
+	t.Helper()
+	cert, pool := newTestCert(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+		io.WriteString(w, testBody)
+	})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h3Port := conn.LocalAddr().(*net.UDPAddr).Port
+	h3Server := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+	}
+	go h3Server.Serve(conn)
+	t.Cleanup(func() {
+		h3Server.Close()
+		conn.Close()
+	})
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%d"; ma=60`, h3Port))
+		handler.ServeHTTP(w, r)
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.URL, h3Port, pool
+}
+
+// newTestTransport 创建信任 pool 的 h3Transport
+func newTestTransport(t *testing.T, mode string, pool *x509.CertPool, d *dialer) *h3Transport {
+	t.Helper()
+	tlsConfig := &tls.Config{RootCAs: pool}
+	base := &http.Transport{TLSClientConfig: tlsConfig.Clone()}
+	if d != nil {
+		base.DialContext = d.DialContext
+	}
+	tr := newH3Transport(base, mode, tlsConfig, nil, nil, d)
+	// 不可达的地址要等握手超时才会失败，缩短超时让测试更快
+	tr.h3.QUICConfig = &quic.Config{HandshakeIdleTimeout: 500 * time.Millisecond}
+	t.Cleanup(func() {
+		tr.CloseIdleConnections()
+		tr.h3.Close()
+	})
+	return tr
+}
+
+// get 下载 rawURL，返回服务器看到的协议版本
+func get(t *testing.T, tr http.RoundTripper, rawURL string) string {
+	t.Helper()
+	resp, err := (&http.Client{Transport: tr, Timeout: 10 * time.Second}).Get(rawURL)
+	if err != nil {
+		t.Fatalf("GET %s: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	if string(body) != testBody {
+		t.Fatalf("响应内容为 %q，期望 %q", body, testBody)
+	}
+	return resp.Header.Get("X-Proto")
+}
+
+func TestHTTP3AltSvcDiscovery(t *testing.T) {
+	rawURL, h3Port, pool := startServers(t)
+	tr := newTestTransport(t, HTTP3Auto, pool, nil)
+
+	if proto := get(t, tr, rawURL); proto != "HTTP/1.1" {
+		t.Fatalf("第一个请求使用了 %s，应当先通过 TCP 发送", proto)
+	}
+	u, _ := url.Parse(rawURL)
+	alt, ok := tr.alts[originOf(u)]
+	if !ok {
+		t.Fatal("没有记录 Alt-Svc 声明的 h3 地址")
+	}
+	if want := net.JoinHostPort("127.0.0.1", fmt.Sprint(h3Port)); alt.addr != want {
+		t.Fatalf("h3 地址为 %s，期望 %s", alt.addr, want)
+	}
+	if proto := get(t, tr, rawURL); proto != "HTTP/3.0" {
+		t.Fatalf("发现 Alt-Svc 之后使用了 %s，期望 HTTP/3.0", proto)
+	}
+}
+
+func TestHTTP3FallbackAfterMarkBroken(t *testing.T) {
+	rawURL, _, pool := startServers(t)
+	tr := newTestTransport(t, HTTP3Auto, pool, nil)
+	get(t, tr, rawURL)
+	if proto := get(t, tr, rawURL); proto != "HTTP/3.0" {
+		t.Fatalf("使用了 %s，期望 HTTP/3.0", proto)
+	}
+
+	u, _ := url.Parse(rawURL)
+	tr.markBroken(originOf(u))
+	if proto := get(t, tr, rawURL); proto != "HTTP/1.1" {
+		t.Fatalf("HTTP/3 被标记为不可用后使用了 %s，期望回退到 TCP", proto)
+	}
+}
+
+func TestHTTP3FallbackWhenUnreachable(t *testing.T) {
+	rawURL, _, pool := startServers(t)
+	tr := newTestTransport(t, HTTP3Auto, pool, nil)
+	u, _ := url.Parse(rawURL)
+	origin := originOf(u)
+
+	// 让 Alt-Svc 指向一个没有 HTTP/3 服务的端口
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadPort := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+	tr.learn(origin, []string{fmt.Sprintf(`h3=":%d"`, deadPort)})
+
+	if proto := get(t, tr, rawURL); proto != "HTTP/1.1" {
+		t.Fatalf("HTTP/3 不可达时使用了 %s，期望回退到 TCP", proto)
+	}
+	if _, broken := tr.broken[origin]; !broken {
+		t.Fatal("HTTP/3 失败后没有标记该源站")
+	}
+}
+
+func TestHTTP3DialTriesEachAddress(t *testing.T) {
+	rawURL, _, pool := startServers(t)
+	u, _ := url.Parse(rawURL)
+	// 127.0.0.2 上没有服务，应当接着尝试 127.0.0.1
+	d, err := newDialer(Config{Hosts: map[string][]string{"localhost": {"127.0.0.2", "127.0.0.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTestTransport(t, HTTP3Auto, pool, d)
+	localURL := "https://localhost:" + u.Port()
+
+	get(t, tr, localURL)
+	if proto := get(t, tr, localURL); proto != "HTTP/3.0" {
+		t.Fatalf("使用了 %s，期望跳过不可达的 IP 后通过 HTTP/3 下载", proto)
+	}
+}
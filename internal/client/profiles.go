@@ -0,0 +1,88 @@
+// internal/client/profiles.go
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// DefaultProfile 是任务没有指定传输配置时使用的配置名，没有定义时使用 GetClient
+const DefaultProfile = "default"
+
+// Profiles 是一组命名的传输配置，每个配置对应的 http.Client 只创建一次，由使用它的所有下载共享连接池
+type Profiles struct {
+	configs map[string]Config
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+// LoadProfiles 从 JSON 文件中读取传输配置，文件内容是配置名到 Config 的映射，例如
+//
+//	{"egress": {"proxy": "socks5://10.0.0.1:1080"}, "internal": {"ca_file": "/etc/ca.pem", "disable_http2": true}}
+//
+// 读取时会创建每一个配置的客户端，以便尽早发现证书路径等错误
+func LoadProfiles(path string) (*Profiles, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取传输配置: %w", err)
+	}
+	var configs map[string]Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("无法解析传输配置: %w", err)
+	}
+	p := &Profiles{configs: configs, clients: make(map[string]*http.Client, len(configs))}
+	for name := range configs {
+		if _, err := p.Client(name); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Names 返回所有配置名，按字母顺序排列
+func (p *Profiles) Names() []string {
+	if p == nil {
+		return nil
+	}
+	names := make([]string, 0, len(p.configs))
+	for name := range p.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Client 返回名为 name 的配置对应的客户端
+// name 为空时使用 DefaultProfile，DefaultProfile 没有定义 (或者 p 为 nil) 时返回 GetClient
+func (p *Profiles) Client(name string) (*http.Client, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	var cfg Config
+	var ok bool
+	if p != nil {
+		cfg, ok = p.configs[name]
+	}
+	if !ok {
+		if name == DefaultProfile {
+			return GetClient(), nil
+		}
+		return nil, fmt.Errorf("未定义的传输配置: %q", name)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.clients[name]; ok {
+		return c, nil
+	}
+	c, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("传输配置 %q 无效: %w", name, err)
+	}
+	p.clients[name] = c
+	return c, nil
+}
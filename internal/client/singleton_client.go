@@ -7,6 +7,17 @@ import (
 	"time"
 )
 
+const (
+	// DialTimeout 是建立 TCP 连接的超时时间
+	DialTimeout = 10 * time.Second
+	// TLSHandshakeTimeout 是 TLS 握手的超时时间
+	TLSHandshakeTimeout = 10 * time.Second
+	// ResponseHeaderTimeout 是发送请求后等待响应头的超时时间
+	ResponseHeaderTimeout = 30 * time.Second
+	// IdleConnTimeout 是空闲连接在连接池中保留的时间
+	IdleConnTimeout = 90 * time.Second
+)
+
 var (
 	instance *http.Client
 	once     sync.Once
@@ -15,13 +26,14 @@ var (
 // GetClient 返回 http.Client 的单例
 // 在第一次被调用时，它会初始化一个自定义配置的 http.Client
 // 后续所有调用都将返回这同一个实例
+//
+// 这里不设置 http.Client.Timeout：它限制的是包括读取响应体在内的整个请求，
+// 会杀死所有传输时间超过该值的大分片。取而代之的是按阶段设置的超时 (建连、TLS 握手、等待响应头)，
+// 读取响应体阶段的停滞由下载器自己的看门狗负责检测
 func GetClient() *http.Client {
 	once.Do(func() {
-		// 在这里可以对 http.Client 进行精细化配置
-		// 例如设置超时时间、自定义 Transport 等
-		instance = &http.Client{
-			Timeout: 30 * time.Second, // 例如，为所有请求设置一个30秒的超时
-		}
+		// 默认配置不读取任何文件，不会失败
+		instance, _ = New(DefaultConfig())
 	})
 	return instance
 }
@@ -0,0 +1,270 @@
+// internal/downloader/adaptive.go
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// adaptiveInterval 是自适应模式下测量吞吐量、调整连接数的间隔
+	adaptiveInterval = 2 * time.Second
+	// adaptiveStartThreads 是自适应模式下初始的连接数
+	adaptiveStartThreads = 2
+	// adaptiveGain 是增加连接后吞吐量至少需要提升的比例，达不到时视为吞吐量已经饱和
+	adaptiveGain = 0.1
+	// adaptiveHold 是回退之后保持当前连接数的间隔数，之后才会再次尝试增加连接
+	adaptiveHold = 5
+)
+
+// concurrencyController 根据实际吞吐量调整连接数
+// 每个间隔尝试增加一些连接，吞吐量随之提升就继续增加；吞吐量不再提升时退回到增加之前的连接数，
+// 服务器返回 429/503 时连接数减半。回退后保持一段时间再重新尝试，以适应网络状况的变化。
+type concurrencyController struct {
+	max       int
+	current   int
+	bytes     atomic.Int64  // 本间隔内收到的字节数
+	throttled atomic.Bool   // 本间隔内服务器是否要求降低请求频率
+	wake      chan struct{} // 服务器限流时通知调整协程立即减少连接，不等到下一个间隔
+
+	baseline float64 // 增加连接之前的吞吐量 (字节/秒)
+	prev     int     // 增加连接之前的连接数
+	probing  bool    // 上一个间隔是否增加了连接，正在等待结果
+	hold     int
+	lastCut  time.Time // 最近一次因为限流减少连接的时间
+}
+
+// newConcurrencyController 创建一个连接数在 [1, max] 之间变化的控制器
+func newConcurrencyController(max int) *concurrencyController {
+	return &concurrencyController{
+		max:     max,
+		current: min(adaptiveStartThreads, max),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// add 记录收到的字节数，c 为 nil 时什么也不做
+func (c *concurrencyController) add(n int64) {
+	if c != nil {
+		c.bytes.Add(n)
+	}
+}
+
+// observe 检查请求的错误，服务器限流时记录下来，c 为 nil 时什么也不做
+func (c *concurrencyController) observe(err error) {
+	var se *statusError
+	if c != nil && errors.As(err, &se) &&
+		(se.StatusCode == http.StatusTooManyRequests || se.StatusCode == http.StatusServiceUnavailable) {
+		c.throttled.Store(true)
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// tick 根据过去 elapsed 时间内的吞吐量计算新的连接数
+func (c *concurrencyController) tick(elapsed time.Duration) int {
+	rate := float64(c.bytes.Swap(0)) / elapsed.Seconds()
+	switch {
+	case c.throttled.Swap(false):
+		// 服务器要求降低请求频率。同一批请求可能先后收到限流响应，一个间隔内只减少一次
+		if time.Since(c.lastCut) < adaptiveInterval {
+			break
+		}
+		c.lastCut = time.Now()
+		c.current = max(1, c.current/2)
+		c.probing = false
+		c.hold = adaptiveHold
+	case c.hold > 0:
+		c.hold--
+	case c.probing && rate > c.baseline*(1+adaptiveGain):
+		// 增加连接带来了明显的提升，继续增加
+		c.grow(rate)
+	case c.probing:
+		// 吞吐量已经饱和，退回到增加之前的连接数
+		c.current = c.prev
+		c.probing = false
+		c.hold = adaptiveHold
+	default:
+		c.grow(rate)
+	}
+	return c.current
+}
+
+// grow 以当前吞吐量为基准增加连接数，已经达到上限时不再增加
+func (c *concurrencyController) grow(rate float64) {
+	if c.current >= c.max {
+		c.probing = false
+		return
+	}
+	c.baseline = rate
+	c.prev = c.current
+	c.current = min(c.max, c.current+max(1, c.current/2))
+	c.probing = true
+}
+
+// errScaledDown 是连接因为连接数减少而被停止时使用的取消原因
+var errScaledDown = errors.New("连接数减少，停止该连接")
+
+// workerPool 管理下载分片的连接，连接数可以在下载过程中调整
+// 减少连接时，多余连接的 ctx 会以 errScaledDown 为原因被取消，它们正在下载的分片放回队列，
+// 由剩下的连接从断点继续，这样服务器限流时能立即减轻压力
+type workerPool struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	ctx      context.Context
+	running  map[int]context.CancelCauseFunc
+	stopping map[int]bool // 已经因为连接数减少被取消、但还没有结束的连接
+	target   int
+	closed   bool // 所有连接都已结束，不再启动新的连接
+	work     func(ctx context.Context, id int)
+	onChange func(live int) // 实际在工作的连接数变化时在单独的协程中调用
+	reportMu sync.Mutex     // 保证按顺序报告连接数
+	reported int
+	updates  chan int      // 等待交给 onChange 的最新连接数，只保留最新的一个
+	notified chan struct{} // 调用 onChange 的协程结束时关闭
+}
+
+// newWorkerPool 创建连接池，每个连接以 ctx 的子 ctx 执行 work，work 返回时连接结束
+// onChange 不为 nil 时，实际在工作的连接数 (不包括正在停止的连接) 变化后会调用它
+// onChange 在单独的协程中按顺序调用，它很慢时 (例如写入 Redis) 不会拖住调整连接数和下载，
+// 期间的多次变化只报告最新的一次
+func newWorkerPool(ctx context.Context, work func(ctx context.Context, id int), onChange func(live int)) *workerPool {
+	p := &workerPool{
+		ctx:      ctx,
+		running:  make(map[int]context.CancelCauseFunc),
+		stopping: make(map[int]bool),
+		work:     work,
+		onChange: onChange,
+		updates:  make(chan int, 1),
+		notified: make(chan struct{}),
+	}
+	go func() {
+		defer close(p.notified)
+		for live := range p.updates {
+			if p.onChange != nil {
+				p.onChange(live)
+			}
+		}
+	}()
+	return p
+}
+
+// resize 把连接数调整为 n，缺少的连接立即启动，多余的连接立即停止
+// 正在停止的连接还没有结束时，它的位置要等它结束后才能由新的连接补上 (见 exit)
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	p.target = n
+	for id, cancel := range p.running {
+		if id >= n && !p.stopping[id] {
+			cancel(errScaledDown)
+			p.stopping[id] = true
+		}
+	}
+	p.fill()
+	p.mu.Unlock()
+	p.report()
+}
+
+// live 返回实际在工作的连接数，调用方需持有 p.mu
+func (p *workerPool) live() int {
+	return len(p.running) - len(p.stopping)
+}
+
+// report 在实际在工作的连接数与上次报告的不同时调用 onChange，连接池关闭后不再报告
+func (p *workerPool) report() {
+	p.reportMu.Lock()
+	defer p.reportMu.Unlock()
+	p.mu.Lock()
+	live, closed := p.live(), p.closed
+	p.mu.Unlock()
+	if closed || live == p.reported || p.onChange == nil {
+		return
+	}
+	p.reported = live
+	// 还没有被取走的旧值已经过时，换成最新的
+	select {
+	case <-p.updates:
+	default:
+	}
+	p.updates <- live
+}
+
+// fill 启动目标数量之内尚未运行的连接，调用方需持有 p.mu
+func (p *workerPool) fill() {
+	if p.closed || p.ctx.Err() != nil {
+		return
+	}
+	for id := 0; id < p.target; id++ {
+		if _, ok := p.running[id]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancelCause(p.ctx)
+		p.running[id] = cancel
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.work(ctx, id)
+		}()
+	}
+}
+
+// exit 记录连接已经结束
+// 因为连接数减少而停止的连接结束后需要补齐连接：它可能把没下载完的分片放回了队列，
+// 而已经因为没有分片可领而结束的连接需要重新启动来接手；连接数在它停止后又增加时，它的位置也要补上
+// 其余连接是因为没有分片可领而结束的，不再补齐，否则补上的连接也领不到分片，会反复启动
+// 最后一个连接结束后连接池关闭：没有连接在下载，也就不会再有分片被放回队列
+func (p *workerPool) exit(id int) {
+	p.mu.Lock()
+	p.running[id](nil)
+	delete(p.running, id)
+	if p.stopping[id] {
+		delete(p.stopping, id)
+		p.fill()
+	}
+	if len(p.running) == 0 {
+		p.closed = true
+	}
+	p.mu.Unlock()
+	p.report()
+}
+
+// wait 等待所有连接结束，以及最后一次连接数报告送达
+func (p *workerPool) wait() {
+	p.wg.Wait()
+	p.reportMu.Lock()
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	close(p.updates)
+	p.reportMu.Unlock()
+	<-p.notified
+}
+
+// adjust 周期性地根据吞吐量调整连接池的大小，直到 ctx 结束
+func (d *Downloader) adjust(ctx context.Context, pool *workerPool) {
+	ticker := time.NewTicker(adaptiveInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.ctrl.wake:
+			ticker.Reset(adaptiveInterval)
+		case <-ctx.Done():
+			return
+		}
+		now := time.Now()
+		before := d.ctrl.current
+		n := d.ctrl.tick(now.Sub(last))
+		last = now
+		if n != before {
+			pool.resize(n)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// internal/downloader/adaptive_test.go
+package downloader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolSlowReport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	var (
+		mu   sync.Mutex
+		seen []int
+	)
+	pool := newWorkerPool(ctx, func(wctx context.Context, id int) {
+		<-wctx.Done()
+	}, func(live int) {
+		// 模拟很慢的 Redis
+		<-release
+		mu.Lock()
+		seen = append(seen, live)
+		mu.Unlock()
+	})
+	// 连接退出时也要通过 exit 记录
+	work := pool.work
+	pool.work = func(wctx context.Context, id int) {
+		work(wctx, id)
+		pool.exit(id)
+	}
+
+	resized := make(chan struct{})
+	go func() {
+		pool.resize(4)
+		pool.resize(2)
+		pool.resize(3)
+		close(resized)
+	}()
+	select {
+	case <-resized:
+	case <-time.After(time.Second):
+		t.Fatal("报告连接数阻塞了调整连接数")
+	}
+
+	// 期间的变化只保留最新的一次，放行后应当报告 3
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		got := append([]int(nil), seen...)
+		mu.Unlock()
+		if len(got) > 0 && got[len(got)-1] == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("报告的连接数为 %v，最后一次应当是 3", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	pool.wait()
+}
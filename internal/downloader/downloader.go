@@ -2,46 +2,208 @@
 package downloader
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/Slade66/parallel-fetcher/internal/client"
 	"github.com/Slade66/parallel-fetcher/internal/observer"
+	"github.com/Slade66/parallel-fetcher/internal/ratelimit"
+	"github.com/Slade66/parallel-fetcher/internal/redact"
 	"github.com/Slade66/parallel-fetcher/internal/uploader"
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
+	"golang.org/x/sync/singleflight"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
+)
+
+const (
+	// manifestSaveInterval 是下载过程中把分片进度写入清单的间隔
+	manifestSaveInterval = time.Second
+	// DefaultStallTimeout 是默认的停滞检测窗口
+	DefaultStallTimeout = 30 * time.Second
+	// maxRestarts 是远程文件在下载过程中发生变化时，最多从头重新下载的次数
+	maxRestarts = 3
 )
 
 // Downloader 结构体封装了下载任务的所有信息
 type Downloader struct {
 	url           string
+	source        string             // 最近一次探测文件信息时请求的地址，URLResolver 提供新地址之前为 url
+	finalURL      string             // 探测时跟随重定向得到的地址
+	resolved      string             // 下载数据时请求的地址，固定重定向时为 finalURL，否则为 source
+	pinRedirects  bool               // 是否固定重定向后的地址
+	resolver      URLResolver        // 下载地址失效时获取新地址的方法
+	targetMu      sync.Mutex         // 保护 source、finalURL 和 resolved，分片在签名过期后会并发地获取新地址
+	resolveGroup  singleflight.Group // 多个分片同时发现地址失效时只获取一次新地址
 	output        string
+	objectKey     string // 上传时使用的对象键，为空时使用 output 中的文件名
 	threads       int
 	contentLen    int64
 	acceptsRanges bool
+	etag          string
+	lastModified  string
+	workDir       string
+	minChunkSize  int64
+	maxChunkSize  int64
+	writeMode     WriteMode
+	stallTimeout  time.Duration
+	checksum      checksum.Digest
+	checksumSet   bool              // 期望摘要是否由 SetExpectedChecksum 指定，否则来自服务器，远程文件变化后随之更新
+	probeSidecar  bool              // 远程文件变化后，新版本的响应头中没有摘要时是否重新探测摘要文件
+	pieceLength   int64             // 分块校验时每一块的长度
+	pieces        []checksum.Digest // 每一块的期望摘要，为空时不分块校验
+	rateLimit     ratelimit.Limiter // 本次下载独享的限速
+	sharedLimit   ratelimit.Limiter // 与其他下载共享的限速，例如整个 Worker 进程的带宽上限
+	adaptive      bool
+	ctrl          *concurrencyController // 自适应模式下调整连接数的控制器
+	stats         transferStats
 	client        *http.Client
+	header        http.Header // 每个请求都要附带的请求头
+	mirrors       []*mirror   // 分片可以选择的下载来源，第一个是原始 URL，没有镜像时为空
+	mirrorMu      sync.Mutex
 	observers     []observer.Observer
 	mu            sync.Mutex
 	uploader      *uploader.ObsUploader
+	retry         RetryPolicy
 }
 
-// New 创建一个新的 Downloader 实例
-func New(url, output string, threads int, size int64, acceptsRanges bool, uploader *uploader.ObsUploader) *Downloader {
+// New 创建一个新的 Downloader 实例，info 是通过 fileinfo.Get 获取的远程文件信息
+func New(url, output string, threads int, info *fileinfo.Info, uploader *uploader.ObsUploader) *Downloader {
 	d := &Downloader{
-		url:           url,
-		output:        output,
-		threads:       threads,
-		contentLen:    size,
-		acceptsRanges: acceptsRanges,
-		client:        client.GetClient(),
-		observers:     make([]observer.Observer, 0),
-		uploader:      uploader, // 新增：赋值 uploader
+		url:          url,
+		source:       url,
+		output:       output,
+		threads:      threads,
+		pinRedirects: true,
+		workDir:      os.TempDir(),
+		minChunkSize: DefaultMinChunkSize,
+		maxChunkSize: DefaultMaxChunkSize,
+		writeMode:    WriteParts,
+		stallTimeout: DefaultStallTimeout,
+		client:       client.GetClient(),
+		observers:    make([]observer.Observer, 0),
+		uploader:     uploader, // 新增：赋值 uploader
+		retry:        DefaultRetryPolicy(),
+	}
+	d.setInfo(info)
+	return d
+}
+
+// setInfo 记录远程文件的信息
+// 期望摘要来自服务器时随之更新，通过 SetExpectedChecksum 指定的摘要保持不变
+func (d *Downloader) setInfo(info *fileinfo.Info) {
+	d.contentLen = info.Size
+	d.acceptsRanges = info.AcceptsRanges
+	d.etag = info.ETag
+	d.lastModified = info.LastModified
+	d.finalURL = info.FinalURL
+	d.resolved = d.source
+	if d.pinRedirects && d.finalURL != "" {
+		d.resolved = d.finalURL
+	}
+	if !d.checksumSet {
+		d.checksum = info.Checksum
 	}
 	// 如果服务器不支持分片下载，强制使用单线程
 	if !d.acceptsRanges {
 		d.threads = 1
 	}
-	return d
+}
+
+// Size 返回文件大小，大小未知的文件在下载完成后才能得到，此前为 fileinfo.UnknownSize
+func (d *Downloader) Size() int64 {
+	return d.contentLen
+}
+
+// SetClient 设置发送请求使用的 http.Client，默认为 client.GetClient()
+// 探测文件信息时应使用同一个客户端，否则代理或证书配置不同可能得到不同的结果
+func (d *Downloader) SetClient(c *http.Client) {
+	d.client = c
+}
+
+// SetObjectKey 设置上传时使用的对象键，默认为输出路径中的文件名
+// 同一批任务中不同目录下的同名文件需要用包含目录的对象键区分，否则后上传的会覆盖先上传的
+func (d *Downloader) SetObjectKey(key string) {
+	d.objectKey = key
+}
+
+// SetRetryPolicy 设置分片下载失败时的重试策略
+func (d *Downloader) SetRetryPolicy(p RetryPolicy) {
+	d.retry = p
+}
+
+// SetWorkDir 设置存放分片和下载清单的目录，默认为系统临时目录
+// 该目录应位于持久化存储上，进程重启后才能断点续传
+func (d *Downloader) SetWorkDir(dir string) {
+	d.workDir = dir
+}
+
+// SetChunkSize 设置分片大小的范围
+// 文件会被切分为大小在 [min, max] 之间的分片，剩余字节少于 2*min 的分片不会再被拆分
+func (d *Downloader) SetChunkSize(min, max int64) {
+	if min < minChunkFloor {
+		min = minChunkFloor
+	}
+	if max < min {
+		max = min
+	}
+	d.minChunkSize = min
+	d.maxChunkSize = max
+}
+
+// SetWriteMode 设置分片数据的落盘方式，默认为 WriteParts
+func (d *Downloader) SetWriteMode(mode WriteMode) {
+	d.writeMode = mode
+}
+
+// SetStallTimeout 设置停滞检测窗口：一个连接超过该时间没有收到任何数据时，中止本次请求并重试
+// 为 0 时不检测停滞
+func (d *Downloader) SetStallTimeout(timeout time.Duration) {
+	d.stallTimeout = timeout
+}
+
+// SetExpectedChecksum 设置文件的期望摘要，下载完成后会在保存或上传之前进行校验
+func (d *Downloader) SetExpectedChecksum(digest checksum.Digest) {
+	d.checksum = digest
+	d.checksumSet = true
+}
+
+// SetProbeSidecar 设置远程文件在下载过程中变化后，新版本的响应头中没有摘要时是否重新探测摘要文件
+// 摘要文件通常与文件一起更新，上一个版本的摘要不能用来校验新版本
+func (d *Downloader) SetProbeSidecar(enabled bool) {
+	d.probeSidecar = enabled
+}
+
+// SetRateLimit 限制本次下载所有连接的总速度 (字节/秒)，0 表示不限速
+func (d *Downloader) SetRateLimit(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		d.rateLimit = nil
+		return
+	}
+	d.rateLimit = ratelimit.NewBucket(bytesPerSecond)
+}
+
+// SetSharedLimiter 设置与其他下载共享的限速器，与 SetRateLimit 同时设置时两者都生效
+func (d *Downloader) SetSharedLimiter(l ratelimit.Limiter) {
+	d.sharedLimit = l
+}
+
+// limiter 返回读取数据时需要经过的限速器，没有限速时返回 nil
+func (d *Downloader) limiter() ratelimit.Limiter {
+	return ratelimit.Join(d.rateLimit, d.sharedLimit)
+}
+
+// SetAdaptive 开启自适应连接数：从少量连接开始，吞吐量持续提升时逐步增加连接，
+// 服务器限流或吞吐量饱和时减少连接。开启后 threads 作为连接数的上限
+func (d *Downloader) SetAdaptive(enabled bool) {
+	d.adaptive = enabled
 }
 
 // AddObserver 实现了 Observable 接口，用于添加观察者
@@ -60,46 +222,380 @@ func (d *Downloader) Notify(downloaded int64) {
 	}
 }
 
+// received 记录从服务器收到的数据并通知观察者
+func (d *Downloader) received(n int64) {
+	d.stats.bytes.Add(n)
+	d.ctrl.add(n)
+	d.Notify(n)
+}
+
+// notifyRestart 通知关心重新下载的观察者
+func (d *Downloader) notifyRestart(totalSize int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, obs := range d.observers {
+		if ro, ok := obs.(observer.RestartObserver); ok {
+			ro.Restart(totalSize)
+		}
+	}
+}
+
+// notifyConcurrency 通知关心连接数的观察者
+func (d *Downloader) notifyConcurrency(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, obs := range d.observers {
+		if co, ok := obs.(observer.ConcurrencyObserver); ok {
+			co.UpdateConcurrency(n)
+		}
+	}
+}
+
+// part 描述了一个分片的字节范围 (闭区间)
+type part struct {
+	index int
+	start int64
+	end   int64
+}
+
+// size 返回分片应有的字节数
+func (p part) size() int64 {
+	return p.end - p.start + 1
+}
+
+// planParts 将文件切分为若干分片
+// 分片大小约为 文件大小/(线程数*chunksPerThread)，并限制在 [minChunkSize, maxChunkSize] 之间
+func (d *Downloader) planParts() []part {
+	if !d.acceptsRanges || d.contentLen <= 0 {
+		return []part{{index: 0, start: 0, end: d.contentLen - 1}}
+	}
+	chunkSize := d.contentLen / int64(d.threads*chunksPerThread)
+	chunkSize = max(d.minChunkSize, min(chunkSize, d.maxChunkSize))
+
+	var parts []part
+	for start := int64(0); start < d.contentLen; start += chunkSize {
+		end := min(start+chunkSize, d.contentLen) - 1
+		parts = append(parts, part{index: len(parts), start: start, end: end})
+	}
+	return parts
+}
+
+// workPaths 返回本次下载使用的临时目录和清单文件的路径
+// 路径由 URL 和输出路径唯一确定，因此重新运行同一个下载时能找到上次的进度
+func (d *Downloader) workPaths() (dir, manifestPath string) {
+	sum := sha256.Sum256([]byte(d.url + "\n" + d.output))
+	name := "fetcher-" + hex.EncodeToString(sum[:8])
+	return filepath.Join(d.workDir, name), filepath.Join(d.workDir, name+".json")
+}
+
+// directPath 返回直接写入模式下数据文件的路径
+// 保存到本地时写在输出路径旁边，完成后原地重命名；需要上传时写在临时目录中
+func (d *Downloader) directPath(tempDir string) string {
+	if d.uploader == nil {
+		return d.output + ".part"
+	}
+	return filepath.Join(tempDir, "data")
+}
+
+// prepareWorkDir 准备临时目录和下载清单
+// 如果存在与当前远程文件版本一致的清单，则沿用上次的分片继续下载 (fresh 为 false)；
+// 否则丢弃旧的分片重新开始
+func (d *Downloader) prepareWorkDir() (tempDir string, m *manifest, fresh bool, err error) {
+	dir, manifestPath := d.workPaths()
+	// 服务器不支持 Range 时无法续传，总是重新开始
+	if d.acceptsRanges {
+		m, err := loadManifest(manifestPath)
+		switch {
+		case err == nil && m.matches(d.url, d.etag, d.lastModified, d.contentLen, d.writeMode):
+			if d.resumable(dir) {
+				if d.writeMode == WriteParts {
+					if err := (&partStore{dir: dir}).prune(m); err != nil {
+						return "", nil, false, fmt.Errorf("无法清理多余的分片文件: %w", err)
+					}
+				}
+				fmt.Println("♻️ 发现未完成的下载记录，将从上次的进度继续...")
+				return dir, m, false, nil
+			}
+		case err == nil:
+			fmt.Println("⚠️ 远程文件已发生变化，丢弃上次下载的分片...")
+		case !errors.Is(err, os.ErrNotExist):
+			fmt.Printf("⚠️ 无法读取下载清单，将重新下载: %v\n", err)
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return "", nil, false, fmt.Errorf("无法清理临时目录: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, false, fmt.Errorf("无法创建临时目录: %w", err)
+	}
+	m = newManifest(manifestPath, d.url, d.etag, d.lastModified, d.contentLen, d.writeMode, d.planParts())
+	if err := m.save(); err != nil {
+		return "", nil, false, fmt.Errorf("无法写入下载清单: %w", err)
+	}
+	return dir, m, true, nil
+}
+
+// resumable 判断上次下载留下的数据是否还在
+func (d *Downloader) resumable(tempDir string) bool {
+	if _, err := os.Stat(tempDir); err != nil {
+		return false
+	}
+	if d.writeMode == WriteDirect {
+		if _, err := os.Stat(d.directPath(tempDir)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// openStore 根据落盘方式打开分片数据的存储
+func (d *Downloader) openStore(tempDir string, fresh bool) (store, error) {
+	if d.writeMode == WriteDirect {
+		return openDirectStore(d.directPath(tempDir), d.contentLen, fresh)
+	}
+	return &partStore{dir: tempDir}, nil
+}
+
+// cleanup 在下载成功后删除临时目录和下载清单
+func (d *Downloader) cleanup(tempDir string) error {
+	_, manifestPath := d.workPaths()
+	if err := os.Remove(manifestPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(tempDir)
+}
+
+// discard 删除本次下载的全部本地数据，包括临时目录、下载清单和直接写入模式的数据文件
+func (d *Downloader) discard(tempDir string) {
+	if d.writeMode == WriteDirect {
+		os.Remove(d.directPath(tempDir))
+	}
+	d.cleanup(tempDir)
+}
+
+// Discard 删除这个下载保留在本地的全部数据，用于放弃一个失败后不会再重新运行的下载
+func (d *Downloader) Discard() {
+	tempDir, _ := d.workPaths()
+	d.discard(tempDir)
+}
+
 // Run 启动下载流程
-func (d *Downloader) Run() error {
+// threads 个连接从调度器中领取分片下载，队列空了之后空闲连接会拆分最慢的分片继续下载
+// 任意分片最终失败时会取消其余分片，并返回汇总了所有失败分片的 *DownloadError
+// 失败时保留临时目录和下载清单，下次运行同一个下载时会从断点继续；
+// 而 ctx 被取消或超时时，会停止所有分片并删除本次下载的全部本地数据
+// 远程文件在下载过程中发生变化时，丢弃已下载的数据，重新获取文件信息后从头下载，最多 maxRestarts 次
+func (d *Downloader) Run(parent context.Context) error {
+	for restarts := 0; ; restarts++ {
+		var err error
+		if d.contentLen < 0 {
+			err = d.runStream(parent)
+		} else {
+			err = d.runParts(parent)
+		}
+		if !errors.Is(err, ErrRemoteChanged) {
+			return err
+		}
+		// 不同版本的数据不能拼接在一起，也不能留给下次续传
+		tempDir, _ := d.workPaths()
+		d.discard(tempDir)
+		if restarts >= maxRestarts {
+			return err
+		}
+		fmt.Printf("\n⚠️ 远程文件在下载过程中发生了变化，重新获取文件信息并从头下载 (第 %d 次)...\n", restarts+1)
+		if err := d.refresh(parent); err != nil {
+			return err
+		}
+	}
+}
+
+// refresh 重新获取远程文件的信息，并通知观察者下载将从头开始
+func (d *Downloader) refresh(ctx context.Context) error {
+	source, err := d.freshSource(ctx)
+	if err != nil {
+		return err
+	}
+	info, err := fileinfo.Get(ctx, source, d.probeOptions()...)
+	if err != nil {
+		return fmt.Errorf("无法重新获取文件信息: %w", err)
+	}
+	if err := d.refreshChecksum(ctx, info); err != nil {
+		return err
+	}
+	d.source = source
+	d.setInfo(info)
+	d.clearMirrors()
+	d.notifyRestart(info.Size)
+	return nil
+}
+
+// refreshChecksum 确保远程文件变化后仍然会校验新版本：上一个版本有来自服务器的摘要，
+// 而新版本的响应头中没有时，重新探测摘要文件；找不到新版本的摘要时返回错误，而不是跳过校验
+func (d *Downloader) refreshChecksum(ctx context.Context, info *fileinfo.Info) error {
+	if d.checksumSet || d.checksum.IsZero() || !info.Checksum.IsZero() {
+		return nil
+	}
+	if !d.probeSidecar {
+		return errors.New("远程文件已经变化，新版本没有提供摘要，无法校验")
+	}
+	digest, source, err := fileinfo.FindSidecarChecksum(ctx, d.url, d.probeOptions()...)
+	if err != nil {
+		return fmt.Errorf("远程文件已经变化，无法获取新版本的摘要: %w", err)
+	}
+	info.Checksum, info.ChecksumSource = digest, source
+	fmt.Printf("🔐 将使用 %s 提供的新摘要进行校验: %s\n", redact.URL(source), digest)
+	return nil
+}
+
+// runParts 把文件切分为分片并行下载
+func (d *Downloader) runParts(parent context.Context) error {
 	if !d.acceptsRanges {
 		fmt.Println("⚠️ 服务器不支持断点续传，将使用单线程下载...")
 	}
-	fmt.Printf("文件总大小: %.2f MB, 使用 %d 个线程\n", float64(d.contentLen)/1024/1024, d.threads)
 
-	tempDir, err := os.MkdirTemp("", "fetcher-*")
+	tempDir, m, fresh, err := d.prepareWorkDir()
+	if err != nil {
+		return err
+	}
+	st, err := d.openStore(tempDir, fresh)
 	if err != nil {
-		return fmt.Errorf("无法创建临时目录: %w", err)
+		return err
+	}
+	defer st.close()
+	s := newScheduler(m, d.minChunkSize, d.acceptsRanges)
+	threads := d.threads
+	if d.adaptive && d.acceptsRanges {
+		d.ctrl = newConcurrencyController(d.threads)
+		threads = d.ctrl.current
+		fmt.Printf("文件总大小: %.2f MB, 切分为 %d 个分片, 自适应连接数 (初始 %d 个，最多 %d 个)\n", float64(d.contentLen)/1024/1024, len(m.Parts), threads, d.threads)
+	} else {
+		fmt.Printf("文件总大小: %.2f MB, 切分为 %d 个分片, 使用 %d 个线程\n", float64(d.contentLen)/1024/1024, len(m.Parts), threads)
+	}
+
+	// 让观察者从已经下载的进度开始计算
+	if resumed := st.restore(s); resumed > 0 {
+		d.Notify(resumed)
 	}
-	// 修改：将 defer os.RemoveAll(tempDir) 移动到 mergeAndUpload 内部，确保上传成功后再删除
-	// defer os.RemoveAll(tempDir)
 
-	var wg sync.WaitGroup
-	blockSize := d.contentLen / int64(d.threads)
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
 
-	for i := 0; i < d.threads; i++ {
-		start := int64(i) * blockSize
-		end := start + blockSize - 1
-		if i == d.threads-1 {
-			end = d.contentLen - 1
+	// 定期把进度写入清单，进程被杀死时最多丢失一个间隔的记录
+	stopSaving, saverDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(saverDone)
+		ticker := time.NewTicker(manifestSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.save(st)
+			case <-stopSaving:
+				return
+			}
 		}
+	}()
 
-		wg.Add(1)
-		go func(partNum int, start, end int64) {
-			defer wg.Done()
-			partPath := filepath.Join(tempDir, fmt.Sprintf("part-%d", partNum))
-			if err := d.downloadPart(partPath, start, end); err != nil {
-				// 在并发的 goroutine 中打印错误，而不是返回
-				fmt.Printf("\n❌ 下载分片 %d 失败: %v\n", partNum, err)
+	var (
+		errMu    sync.Mutex
+		partErrs []*PartError
+	)
+	var pool *workerPool
+	pool = newWorkerPool(ctx, func(wctx context.Context, id int) {
+		for wctx.Err() == nil {
+			i, ok := s.next()
+			if !ok {
+				break
 			}
-		}(i, start, end)
+			err := d.downloadPart(wctx, s, i, st)
+			if err != nil && ctx.Err() == nil && errors.Is(context.Cause(wctx), errScaledDown) {
+				// 连接数减少，分片交给其他连接继续
+				s.requeue(i)
+				pool.exit(id)
+				return
+			}
+			s.done(i)
+			if err == nil {
+				continue
+			}
+			// 因为其他分片失败或整个任务被取消而中断的分片不单独记录
+			if ctx.Err() != nil {
+				break
+			}
+			var pe *PartError
+			if !errors.As(err, &pe) {
+				p, _ := s.state(i)
+				pe = newPartError(p, 0, err)
+			}
+			errMu.Lock()
+			partErrs = append(partErrs, pe)
+			errMu.Unlock()
+			// 一个分片最终失败，整个下载都无法完成，立即取消其余分片
+			cancel()
+			break
+		}
+		pool.exit(id)
+	}, d.notifyConcurrency)
+	started := time.Now()
+	pool.resize(threads)
+
+	// 自适应模式下根据吞吐量调整连接数，直到所有连接结束
+	stopAdjusting, cancelAdjusting := context.WithCancel(ctx)
+	adjustDone := make(chan struct{})
+	if d.ctrl != nil {
+		go func() {
+			defer close(adjustDone)
+			d.adjust(stopAdjusting, pool)
+		}()
+	} else {
+		close(adjustDone)
+	}
+	pool.wait()
+	cancelAdjusting()
+	<-adjustDone
+	d.stats.duration = time.Since(started)
+	d.stats.threads = threads
+	if d.ctrl != nil {
+		d.stats.threads = d.ctrl.current
+	}
+	close(stopSaving)
+	<-saverDone
+	if err := parent.Err(); err != nil {
+		st.close()
+		d.discard(tempDir)
+		return fmt.Errorf("下载已取消: %w", err)
+	}
+	if err := s.save(st); err != nil {
+		fmt.Printf("\n⚠️ 无法写入下载清单: %v\n", err)
 	}
-	wg.Wait()
 
-	// 修改：调用新的合并上传方法
-	fmt.Println("\n⏬ 所有分片下载完成，开始合并并上传到 OBS...")
-	if err := d.mergeAndUpload(tempDir); err != nil {
-		return fmt.Errorf("合并或上传文件失败: %w", err)
+	if len(partErrs) == 0 {
+		partErrs = st.verify(s)
+	}
+	if len(partErrs) > 0 {
+		sort.Slice(partErrs, func(i, j int) bool { return partErrs[i].Part < partErrs[j].Part })
+		return &DownloadError{Parts: partErrs}
+	}
+
+	if err := d.verifyPieces(parent, st, s.parts()); err != nil {
+		if parent.Err() != nil {
+			st.close()
+			d.discard(tempDir)
+			return fmt.Errorf("下载已取消: %w", parent.Err())
+		}
+		return err
+	}
+
+	if d.writeMode == WriteParts {
+		fmt.Println("\n⏬ 所有分片下载完成，开始合并...")
+	}
+	if err := d.finalize(parent, tempDir, st, s.parts()); err != nil {
+		if parent.Err() != nil {
+			d.discard(tempDir)
+			return fmt.Errorf("合并或上传文件失败: %w", err)
+		}
+		return &FinalizeError{Err: err}
 	}
 
 	return nil
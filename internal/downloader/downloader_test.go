@@ -0,0 +1,198 @@
+// internal/downloader/downloader_test.go
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
+)
+
+func TestRunEmptyFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "empty", time.Time{}, strings.NewReader(""))
+	}))
+	defer srv.Close()
+
+	for _, mode := range []WriteMode{WriteParts, WriteDirect} {
+		for _, ranges := range []bool{true, false} {
+			dir := t.TempDir()
+			output := filepath.Join(dir, "empty")
+			d := New(srv.URL, output, 4, &fileinfo.Info{Size: 0, AcceptsRanges: ranges}, nil)
+			d.SetWorkDir(dir)
+			d.SetWriteMode(mode)
+			if err := d.Run(context.Background()); err != nil {
+				t.Fatalf("%s 模式 (Range %v) 下载空文件失败: %v", mode, ranges, err)
+			}
+			info, err := os.Stat(output)
+			if err != nil {
+				t.Fatalf("%s 模式 (Range %v) 没有生成输出文件: %v", mode, ranges, err)
+			}
+			if info.Size() != 0 {
+				t.Fatalf("%s 模式 (Range %v) 输出文件有 %d 字节，期望 0", mode, ranges, info.Size())
+			}
+		}
+	}
+}
+
+func TestResumeDropsUnrecordedParts(t *testing.T) {
+	dir := t.TempDir()
+	d := New("http://example.invalid/file", filepath.Join(dir, "file"), 2, &fileinfo.Info{Size: 4 << 20, AcceptsRanges: true, ETag: `"v1"`}, nil)
+	d.SetWorkDir(dir)
+	d.SetChunkSize(1<<20, 1<<20)
+	tempDir, m, _, err := d.prepareWorkDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟崩溃前拆分出的分片：分片文件已经写入，但清单中没有记录
+	orphan := part{index: len(m.Parts), start: 0, end: 99}
+	ps := &partStore{dir: tempDir}
+	if err := os.WriteFile(ps.path(orphan), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, fresh, err := d.prepareWorkDir(); err != nil || fresh {
+		t.Fatalf("应当从清单续传，fresh = %v, err = %v", fresh, err)
+	}
+	if _, err := os.Stat(ps.path(orphan)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("清单中没有记录的分片文件没有被删除: %v", err)
+	}
+
+	// 还没有下载过的分片重新打开时，残留的数据应当被清空
+	if err := os.WriteFile(ps.path(orphan), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, _, err := ps.openPart(orphan, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if info, err := os.Stat(ps.path(orphan)); err != nil || info.Size() != 0 {
+		t.Fatalf("新分片的文件没有被清空: %v", err)
+	}
+}
+
+func TestResumable(t *testing.T) {
+	mismatch := &checksum.MismatchError{}
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&FinalizeError{Err: errors.New("上传失败")}, true},
+		{&FinalizeError{Err: mismatch}, false},
+		{&DownloadError{Parts: []*PartError{{Err: &statusError{StatusCode: 404, Status: "404 Not Found"}}}}, false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, c := range cases {
+		if got := Resumable(c.err); got != c.want {
+			t.Errorf("Resumable(%v) = %v，期望 %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRefreshKeepsVerifying(t *testing.T) {
+	const newContent = "version 2"
+	sum := sha256.Sum256([]byte(newContent))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.bin":
+			http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(newContent))
+		case "/SHA256SUMS":
+			fmt.Fprintf(w, "%s  file.bin\n", hex.EncodeToString(sum[:]))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// 上一个版本的摘要来自摘要文件
+	old, err := checksum.New(checksum.SHA256, strings.Repeat("0", 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDownloader := func() *Downloader {
+		d := New(srv.URL+"/file.bin", filepath.Join(t.TempDir(), "file.bin"), 1, &fileinfo.Info{Size: 9, AcceptsRanges: true, Checksum: old}, nil)
+		d.SetClient(srv.Client())
+		return d
+	}
+
+	d := newDownloader()
+	d.SetProbeSidecar(true)
+	if err := d.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.checksum.String(); got != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Fatalf("远程文件变化后的期望摘要为 %s，期望新版本的摘要", got)
+	}
+
+	// 不能探测摘要文件时，宁可失败也不跳过校验
+	if err := newDownloader().refresh(context.Background()); err == nil {
+		t.Fatal("新版本没有摘要时 refresh 应当返回错误")
+	}
+}
+
+func TestFetchPieceRetriesMismatch(t *testing.T) {
+	const content = "0123456789abcdef"
+	var corrupt atomic.Int32
+	corrupt.Store(1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := content
+		// 第一次返回损坏的数据
+		if corrupt.Add(-1) >= 0 {
+			body = strings.Repeat("x", len(content))
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader(body))
+	}))
+	defer srv.Close()
+
+	var pieces []checksum.Digest
+	for i := 0; i < len(content); i += 8 {
+		sum := sha256.Sum256([]byte(content[i : i+8]))
+		digest, err := checksum.New(checksum.SHA256, hex.EncodeToString(sum[:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pieces = append(pieces, digest)
+	}
+	d := New(srv.URL, filepath.Join(t.TempDir(), "file.bin"), 1, &fileinfo.Info{Size: int64(len(content)), AcceptsRanges: true}, nil)
+	d.SetClient(srv.Client())
+	d.SetRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	if err := d.SetPieceChecksums(8, pieces); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := d.fetchPiece(context.Background(), f, 1); err != nil {
+		t.Fatalf("数据不一致后重试应当成功: %v", err)
+	}
+	if n := d.stats.errors.Load(); n != 1 {
+		t.Fatalf("记录了 %d 次错误，期望 1 次", n)
+	}
+	got := make([]byte, 8)
+	if _, err := f.ReadAt(got, 8); err != nil || string(got) != content[8:] {
+		t.Fatalf("重新下载的数据块为 %q (%v)，期望 %q", got, err, content[8:])
+	}
+
+	// 数据一直不一致时，重试次数耗尽后返回 *checksum.MismatchError
+	corrupt.Store(2)
+	var mismatch *checksum.MismatchError
+	if err := d.fetchPiece(context.Background(), f, 1); !errors.As(err, &mismatch) {
+		t.Fatalf("重试次数耗尽后返回了 %v，期望 *checksum.MismatchError", err)
+	}
+}
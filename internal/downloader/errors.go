@@ -0,0 +1,98 @@
+// internal/downloader/errors.go
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+)
+
+// ErrPartIncomplete 表示分片文件的字节数与其字节范围不一致
+var ErrPartIncomplete = errors.New("分片数据不完整")
+
+// ErrRemoteChanged 表示远程文件在下载过程中被替换成了另一个版本，已下载的数据不能再与后续数据拼接
+var ErrRemoteChanged = errors.New("远程文件在下载过程中发生了变化")
+
+// FinalizeError 表示所有分片都已下载完成，但合并、保存或上传文件失败
+// 分片和下载清单会被保留，重新运行同一个下载时直接从这一步继续，不需要再次下载
+type FinalizeError struct {
+	Err error
+}
+
+func (e *FinalizeError) Error() string {
+	return "合并或上传文件失败: " + e.Err.Error()
+}
+
+func (e *FinalizeError) Unwrap() error {
+	return e.Err
+}
+
+// Resumable 判断 Run 返回的错误是否可以通过重新运行同一个下载来继续：
+// 只有数据已经完整下载、本地数据被保留下来的 *FinalizeError 才值得稍后重试，
+// 其余错误 (分片重试次数耗尽、4xx、摘要不一致、任务被取消或超时) 重新运行也只会再次失败或从头下载
+func Resumable(err error) bool {
+	var fe *FinalizeError
+	return errors.As(err, &fe) && !errors.As(err, new(*checksum.MismatchError))
+}
+
+// PartError 描述了单个分片下载失败的详细信息，可以通过 errors.As 获取
+type PartError struct {
+	Part       int   // 分片序号
+	Start      int64 // 分片起始字节 (包含)
+	End        int64 // 分片结束字节 (包含)
+	StatusCode int   // 最后一次请求的 HTTP 状态码，网络错误时为 0
+	Attempts   int   // 总共尝试的次数
+	Err        error // 导致失败的原因
+}
+
+func (e *PartError) Error() string {
+	msg := fmt.Sprintf("分片 %d [%d-%d] 在 %d 次尝试后失败", e.Part, e.Start, e.End, e.Attempts)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (HTTP %d)", e.StatusCode)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *PartError) Unwrap() error {
+	return e.Err
+}
+
+// DownloadError 汇总了一次下载中所有失败的分片
+type DownloadError struct {
+	Parts []*PartError
+}
+
+func (e *DownloadError) Error() string {
+	msgs := make([]string, len(e.Parts))
+	for i, p := range e.Parts {
+		msgs[i] = p.Error()
+	}
+	return fmt.Sprintf("%d 个分片下载失败: %s", len(e.Parts), strings.Join(msgs, "; "))
+}
+
+// Unwrap 让 errors.Is / errors.As 可以匹配到任意一个分片的错误
+func (e *DownloadError) Unwrap() []error {
+	errs := make([]error, len(e.Parts))
+	for i, p := range e.Parts {
+		errs[i] = p
+	}
+	return errs
+}
+
+// newPartError 根据最后一次失败的原因构造 PartError
+func newPartError(p part, attempts int, err error) *PartError {
+	pe := &PartError{
+		Part:     p.index,
+		Start:    p.start,
+		End:      p.end,
+		Attempts: attempts,
+		Err:      err,
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		pe.StatusCode = se.StatusCode
+	}
+	return pe
+}
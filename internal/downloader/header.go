@@ -0,0 +1,74 @@
+// internal/downloader/header.go
+package downloader
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
+)
+
+// credentialHeaders 是重定向到其他主机后不再发送的请求头，与 net/http 跟随重定向时的规则一致
+var credentialHeaders = []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"}
+
+// SetHeader 设置每个请求都要附带的请求头，例如 User-Agent、Cookie 或认证信息
+// 固定的重定向地址不在原始 URL 的主机 (或其子域名) 上时，不会向它发送 Authorization 和 Cookie，
+// 避免把凭据泄露给 CDN，也避免与预签名 URL 自带的签名冲突
+func (d *Downloader) SetHeader(h http.Header) {
+	d.header = h
+}
+
+// applyHeader 把自定义请求头添加到发往 target 的请求中
+func (d *Downloader) applyHeader(req *http.Request, target string) {
+	for k, vs := range d.HeaderFor(target) {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+}
+
+// HeaderFor 返回发往 target 的请求应当附带的自定义请求头
+// target 不在原始 URL 的主机 (或其子域名) 上时去掉 Authorization 和 Cookie，探测镜像时也要使用它
+func (d *Downloader) HeaderFor(target string) http.Header {
+	if sameSite(d.url, target) {
+		return d.header
+	}
+	h := make(http.Header, len(d.header))
+	for k, vs := range d.header {
+		if !isCredentialHeader(k) {
+			h[k] = vs
+		}
+	}
+	return h
+}
+
+// probeOptions 返回重新探测文件信息时使用的选项，与下载时使用相同的客户端和请求头
+func (d *Downloader) probeOptions() []fileinfo.Option {
+	return []fileinfo.Option{fileinfo.WithClient(d.client), fileinfo.WithHeader(d.header)}
+}
+
+// isCredentialHeader 判断请求头是否携带凭据
+func isCredentialHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, h := range credentialHeaders {
+		if name == h {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSite 判断 target 的主机是否与 origin 相同，或者是它的子域名
+func sameSite(origin, target string) bool {
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	t, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	oh, th := strings.ToLower(o.Hostname()), strings.ToLower(t.Hostname())
+	return th == oh || strings.HasSuffix(th, "."+oh)
+}
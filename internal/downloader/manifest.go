@@ -0,0 +1,94 @@
+// internal/downloader/manifest.go
+package downloader
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// manifest 记录了一次下载的远程文件信息和每个分片的进度
+// 它保存在临时目录旁边，进程崩溃或任务被重新投递后可以据此断点续传
+type manifest struct {
+	URL          string         `json:"url"`
+	ETag         string         `json:"etag,omitempty"`
+	LastModified string         `json:"last_modified,omitempty"`
+	Size         int64          `json:"size"`
+	Mode         WriteMode      `json:"mode"`
+	Parts        []manifestPart `json:"parts"`
+
+	path string
+}
+
+// manifestPart 记录了单个分片的字节范围和已完成的字节数
+// 分片在下载过程中可能被拆分，此时 End 会缩短，拆出的后半段作为新的分片追加到末尾
+type manifestPart struct {
+	Index     int   `json:"index"`
+	Start     int64 `json:"start"`
+	End       int64 `json:"end"`
+	Completed int64 `json:"completed"`
+}
+
+// newManifest 根据分片划分创建一个新的清单
+func newManifest(path, url, etag, lastModified string, size int64, mode WriteMode, parts []part) *manifest {
+	m := &manifest{
+		URL:          url,
+		ETag:         etag,
+		LastModified: lastModified,
+		Size:         size,
+		Mode:         mode,
+		Parts:        make([]manifestPart, len(parts)),
+		path:         path,
+	}
+	for i, p := range parts {
+		m.Parts[i] = manifestPart{Index: p.index, Start: p.start, End: p.end}
+	}
+	return m
+}
+
+// loadManifest 从磁盘读取清单，文件不存在时返回 os.ErrNotExist
+func loadManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{path: path}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// matches 判断清单记录的远程文件与当前的远程文件是否为同一个版本，且使用了相同的落盘方式
+func (m *manifest) matches(url, etag, lastModified string, size int64, mode WriteMode) bool {
+	if m.URL != url || m.Size != size || m.Mode != mode || len(m.Parts) == 0 {
+		return false
+	}
+	if m.ETag != "" || etag != "" {
+		return m.ETag == etag
+	}
+	return m.LastModified == lastModified
+}
+
+// encode 将清单序列化为 JSON
+// 清单本身不加锁，并发修改时由调用方 (scheduler) 负责同步
+func (m *manifest) encode() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// write 将序列化后的清单原子地写入磁盘：先写临时文件再重命名，避免崩溃时留下半个清单
+func (m *manifest) write(data []byte) error {
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// save 将清单写入磁盘
+func (m *manifest) save() error {
+	data, err := m.encode()
+	if err != nil {
+		return err
+	}
+	return m.write(data)
+}
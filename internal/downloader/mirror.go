@@ -0,0 +1,241 @@
+// internal/downloader/mirror.go
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Slade66/parallel-fetcher/internal/redact"
+	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
+)
+
+const (
+	// slowMirrorGrace 是请求镜像之后，开始判断它是否过慢之前等待的时间
+	slowMirrorGrace = 3 * time.Second
+	// slowMirrorRatio 是镜像的速度低于其他来源中最快者的这个比例时停止使用它
+	slowMirrorRatio = 0.1
+)
+
+// errSlowMirror 表示请求因为镜像过慢而被中止
+var errSlowMirror = errors.New("镜像速度过慢")
+
+// mirror 是分片可以选择的一个下载来源：原始 URL (primary) 或者内容相同的镜像
+type mirror struct {
+	url          string // 镜像的下载地址，原始 URL 使用 Downloader.target()
+	primary      bool
+	etag         string // 镜像自己的 ETag 和 Last-Modified，用于 If-Range
+	lastModified string
+
+	active   int           // 正在使用该来源的连接数
+	bytes    int64         // 从该来源收到的数据量
+	busy     time.Duration // 从该来源读取数据花费的时间，bytes/busy 是单连接速度
+	failures int           // 连续失败的次数，成功一次后清零
+	dropped  bool
+}
+
+// isMirror 判断 m 是否是镜像 (而不是原始 URL)
+func (m *mirror) isMirror() bool {
+	return m != nil && !m.primary
+}
+
+// speed 返回观测到的单连接速度 (字节/秒)，还没有数据时返回 0
+func (m *mirror) speed() float64 {
+	if m.busy <= 0 {
+		return 0
+	}
+	return float64(m.bytes) / m.busy.Seconds()
+}
+
+// AddMirror 添加一个与原始 URL 内容相同的下载地址，info 是通过 fileinfo.Get 获取的镜像的文件信息
+// 镜像必须支持 Range 且文件大小相同；双方都提供了同一算法的摘要时，摘要也必须相同
+// 不同服务器的 ETag 通常不同，因此不比较 ETag，而是各自用于 If-Range
+//
+// 分片优先交给单连接速度高、当前连接少的来源；镜像出错、停滞或明显慢于最快来源时不再使用，
+// 它的分片从断点开始改由其他来源继续下载。原始 URL 始终保留
+func (d *Downloader) AddMirror(url string, info *fileinfo.Info) error {
+	if !d.acceptsRanges || d.contentLen < 0 {
+		return errors.New("原始地址不支持分片下载，无法使用镜像")
+	}
+	if !info.AcceptsRanges {
+		return errors.New("镜像不支持分片下载")
+	}
+	if info.Size != d.contentLen {
+		return fmt.Errorf("镜像的文件大小 %d 与原始地址的 %d 不一致", info.Size, d.contentLen)
+	}
+	if !d.checksum.IsZero() && !info.Checksum.IsZero() && d.checksum.Algorithm == info.Checksum.Algorithm &&
+		!bytes.Equal(d.checksum.Value, info.Checksum.Value) {
+		return fmt.Errorf("镜像的 %s 摘要与原始地址不一致", info.Checksum.Algorithm)
+	}
+
+	target := url
+	if d.pinRedirects && info.FinalURL != "" {
+		target = info.FinalURL
+	}
+	d.mirrorMu.Lock()
+	defer d.mirrorMu.Unlock()
+	if len(d.mirrors) == 0 {
+		d.mirrors = append(d.mirrors, &mirror{primary: true})
+	}
+	d.mirrors = append(d.mirrors, &mirror{url: target, etag: info.ETag, lastModified: info.LastModified})
+	return nil
+}
+
+// Mirrors 返回仍在使用的镜像数量，不包括原始 URL
+func (d *Downloader) Mirrors() int {
+	d.mirrorMu.Lock()
+	defer d.mirrorMu.Unlock()
+	n := 0
+	for _, m := range d.mirrors {
+		if m.isMirror() && !m.dropped {
+			n++
+		}
+	}
+	return n
+}
+
+// pickMirror 为一次分片请求选择来源，没有镜像时返回 nil (使用原始 URL)
+// 选择 单连接速度/(连接数+1)/(连续失败次数+1) 最大的来源；还没有测出速度的来源按目前最快的速度计算，
+// 让每个来源都有机会被测速
+func (d *Downloader) pickMirror() *mirror {
+	d.mirrorMu.Lock()
+	defer d.mirrorMu.Unlock()
+	if len(d.mirrors) == 0 {
+		return nil
+	}
+	fastest := 1.0
+	for _, m := range d.mirrors {
+		if !m.dropped {
+			fastest = max(fastest, m.speed())
+		}
+	}
+	var best *mirror
+	var bestScore float64
+	for _, m := range d.mirrors {
+		if m.dropped {
+			continue
+		}
+		speed := m.speed()
+		if speed == 0 {
+			speed = fastest
+		}
+		score := speed / float64(m.active+1) / float64(m.failures+1)
+		if best == nil || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	best.active++
+	return best
+}
+
+// mirrorTarget 返回向来源 m 请求数据时使用的 URL
+func (d *Downloader) mirrorTarget(m *mirror) string {
+	if m.isMirror() {
+		return m.url
+	}
+	return d.target()
+}
+
+// releaseMirror 记录一次请求的结果：收到 n 字节的数据花费了 elapsed，failed 表示请求失败
+func (d *Downloader) releaseMirror(m *mirror, n int64, elapsed time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	d.mirrorMu.Lock()
+	defer d.mirrorMu.Unlock()
+	m.active--
+	m.bytes += n
+	m.busy += elapsed
+	if failed {
+		m.failures++
+	} else if n > 0 {
+		m.failures = 0
+	}
+}
+
+// mirrorCtx 为向来源 m 发出的一次请求创建 ctx，received 返回这次请求已经收到的字节数
+// m 是镜像时，请求开始 slowMirrorGrace 之后每秒检查一次速度，低于其他来源中最快者的 slowMirrorRatio 时
+// 以 errSlowMirror 取消请求；调用方必须在请求结束后调用 stop
+func (d *Downloader) mirrorCtx(ctx context.Context, m *mirror, received func() int64) (mctx context.Context, stop func()) {
+	if !m.isMirror() {
+		return ctx, func() {}
+	}
+	mctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		started := time.Now()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			elapsed := time.Since(started)
+			if elapsed < slowMirrorGrace {
+				continue
+			}
+			if speed := float64(received()) / elapsed.Seconds(); speed < d.fastestOther(m)*slowMirrorRatio {
+				cancel(errSlowMirror)
+				return
+			}
+		}
+	}()
+	return mctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// fastestOther 返回除 m 以外仍在使用的来源中最快的速度
+func (d *Downloader) fastestOther(m *mirror) float64 {
+	d.mirrorMu.Lock()
+	defer d.mirrorMu.Unlock()
+	fastest := 0.0
+	for _, o := range d.mirrors {
+		if o != m && !o.dropped {
+			fastest = max(fastest, o.speed())
+		}
+	}
+	return fastest
+}
+
+// dropMirror 在镜像请求失败后停止使用它，返回 false 表示 m 不是镜像，应按原始 URL 的规则重试
+func (d *Downloader) dropMirror(m *mirror, err error) bool {
+	if !m.isMirror() {
+		return false
+	}
+	d.mirrorMu.Lock()
+	defer d.mirrorMu.Unlock()
+	if m.dropped {
+		return true
+	}
+	m.dropped = true
+	if errors.Is(err, errSlowMirror) {
+		fmt.Printf("\n🪞 镜像 %s 速度过慢，已停止使用\n", redact.URL(m.url))
+	} else {
+		fmt.Printf("\n🪞 镜像 %s 出错，已停止使用: %s\n", redact.URL(m.url), redact.Text(err.Error()))
+	}
+	return true
+}
+
+// clearMirrors 在原始 URL 的文件变化后停止使用所有镜像，无法确认它们是否也换成了新的版本
+func (d *Downloader) clearMirrors() {
+	d.mirrorMu.Lock()
+	defer d.mirrorMu.Unlock()
+	if len(d.mirrors) > 1 {
+		fmt.Println("🪞 原始地址的文件已变化，不再使用镜像")
+	}
+	d.mirrors = nil
+}
+
+// mirrorIfRange 返回向来源 m 发送的 If-Range 请求头的值
+func (d *Downloader) mirrorIfRange(m *mirror) string {
+	if m.isMirror() {
+		return ifRangeValue(m.etag, m.lastModified)
+	}
+	return ifRangeValue(d.etag, d.lastModified)
+}
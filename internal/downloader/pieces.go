@@ -0,0 +1,180 @@
+// internal/downloader/pieces.go
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+)
+
+// SetPieceChecksums 设置文件按 length 字节切分后每一块的期望摘要，例如 Metalink 中的 <pieces>
+// 所有分片下载完成后逐块校验，只重新下载校验失败的块，而不必因为整个文件的摘要不一致而全部重来
+// 块数与文件大小不符时返回错误
+func (d *Downloader) SetPieceChecksums(length int64, pieces []checksum.Digest) error {
+	if length <= 0 || len(pieces) == 0 {
+		return errors.New("分块长度和分块摘要不能为空")
+	}
+	if d.contentLen < 0 {
+		return errors.New("文件大小未知，无法按块校验")
+	}
+	if want := (d.contentLen + length - 1) / length; int64(len(pieces)) != want {
+		return fmt.Errorf("文件大小 %d 按 %d 字节分块应有 %d 块，实际提供了 %d 块摘要", d.contentLen, length, want, len(pieces))
+	}
+	for i, p := range pieces {
+		if p.IsZero() || p.NewHash() == nil {
+			return fmt.Errorf("第 %d 块的摘要无效", i)
+		}
+	}
+	d.pieceLength, d.pieces = length, pieces
+	return nil
+}
+
+// pieceRange 返回第 k 块的字节范围 (闭区间)
+func (d *Downloader) pieceRange(k int) (start, end int64) {
+	start = int64(k) * d.pieceLength
+	return start, min(start+d.pieceLength, d.contentLen) - 1
+}
+
+// verifyPieces 在所有分片下载完成后逐块校验数据，并重新下载校验失败的块
+// 重新下载的数据直接写回原位置并同时计算摘要，仍然不一致时按重试策略重试
+func (d *Downloader) verifyPieces(ctx context.Context, st store, parts []part) error {
+	if len(d.pieces) == 0 {
+		return nil
+	}
+	// 远程文件变化后大小可能不同，原来的分块摘要不再适用
+	if int64(len(d.pieces)) != (d.contentLen+d.pieceLength-1)/d.pieceLength {
+		return fmt.Errorf("文件大小已变为 %d，与分块摘要不符", d.contentLen)
+	}
+	cf, err := st.contents(parts)
+	if err != nil {
+		return err
+	}
+	defer cf.Close()
+
+	bad, err := d.badPieces(cf)
+	if err != nil {
+		return err
+	}
+	if len(bad) == 0 {
+		fmt.Printf("\n🧩 %d 个数据块全部校验通过\n", len(d.pieces))
+		return nil
+	}
+	// 镜像都支持 Range (AddMirror 会检查)，原始地址不支持时也就没有镜像，只能整个文件重新下载
+	if !d.acceptsRanges {
+		return fmt.Errorf("%d 个数据块校验失败，服务器不支持 Range，无法只重新下载损坏的块", len(bad))
+	}
+	fmt.Printf("\n🧩 %d 个数据块校验失败，重新下载这些块...\n", len(bad))
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := make(chan struct{}, max(d.threads, 1))
+	for _, k := range bad {
+		start, end := d.pieceRange(k)
+		// 撤销这一块已经汇报过的进度
+		d.Notify(-(end - start + 1))
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(k int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := d.fetchPiece(ctx, cf, k); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("数据块 %d [%d-%d]: %w", k, start, end, err))
+				mu.Unlock()
+			}
+		}(k)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("%d 个数据块重新下载失败: %w", len(errs), errors.Join(errs...))
+	}
+	fmt.Println("🧩 损坏的数据块已重新下载并通过校验")
+	return nil
+}
+
+// badPieces 依次读取每一块并计算摘要，返回与期望摘要不一致的块的序号
+func (d *Downloader) badPieces(r io.ReaderAt) ([]int, error) {
+	var bad []int
+	for k, want := range d.pieces {
+		start, end := d.pieceRange(k)
+		h := want.NewHash()
+		if _, err := io.Copy(h, io.NewSectionReader(r, start, end-start+1)); err != nil {
+			return nil, fmt.Errorf("读取数据块 %d 失败: %w", k, err)
+		}
+		if want.Verify(h.Sum(nil)) != nil {
+			bad = append(bad, k)
+		}
+	}
+	return bad, nil
+}
+
+// fetchPiece 重新下载第 k 块并写入 w，按重试策略重试，直到数据与期望摘要一致
+// 每次尝试都重新选择来源：返回损坏数据或出错的镜像不再使用，原始地址返回的数据不一致时按普通错误重试
+func (d *Downloader) fetchPiece(ctx context.Context, w io.WriterAt, k int) error {
+	start, end := d.pieceRange(k)
+	var got byteCounter
+	_, err := d.withRetry(ctx,
+		func() string { return fmt.Sprintf("数据块 %d [%d-%d] ", k, start, end) },
+		got.Load,
+		func(ctx context.Context, target, ifRange string) error {
+			before := got.Load()
+			err := d.fetchPieceFrom(ctx, w, k, target, ifRange, &got)
+			if err != nil {
+				// 这次收到的数据作废
+				d.Notify(-(got.Load() - before))
+			}
+			return err
+		})
+	return err
+}
+
+// fetchPieceFrom 从 target 下载第 k 块，边写入 w 边计算摘要，收到的字节数累加到 got 中
+// 数据与期望摘要不一致时返回 *checksum.MismatchError，此时写入的数据仍然是损坏的
+func (d *Downloader) fetchPieceFrom(ctx context.Context, w io.WriterAt, k int, target, ifRange string, got *byteCounter) error {
+	start, end := d.pieceRange(k)
+	resp, err := d.openRange(ctx, target, start, end, ifRange)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	if resp.StatusCode == http.StatusOK {
+		// 带有 If-Range 时说明文件已经换成了另一个版本，否则是服务器忽略了 Range
+		if ifRange != "" {
+			return ErrRemoteChanged
+		}
+		return errRangeIgnored
+	}
+
+	want := d.pieces[k]
+	h := want.NewHash()
+	n, err := io.Copy(io.MultiWriter(io.NewOffsetWriter(w, start), h, got), d.body(resp, io.LimitReader(resp.Body, end-start+1)))
+	if err != nil {
+		return resp.err(err)
+	}
+	if n < end-start+1 {
+		return io.ErrUnexpectedEOF
+	}
+	return want.Verify(h.Sum(nil))
+}
+
+// byteCounter 统计写入的字节数，写入的同时可以被其他 goroutine 读取
+type byteCounter struct {
+	atomic.Int64
+}
+
+// Write 实现 io.Writer 接口
+func (c *byteCounter) Write(b []byte) (int, error) {
+	c.Add(int64(len(b)))
+	return len(b), nil
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+// internal/downloader/prealloc_linux.go
+package downloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate 使用 fallocate 为文件预留 size 字节的磁盘空间
+// 文件系统不支持 fallocate 时 (例如部分 NFS) 退化为稀疏文件
+func preallocate(f *os.File, size int64) error {
+	if size > 0 {
+		if err := syscall.Fallocate(int(f.Fd()), 0, 0, size); err == nil {
+			return nil
+		}
+	}
+	return f.Truncate(size)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+// internal/downloader/prealloc_other.go
+package downloader
+
+import "os"
+
+// preallocate 把文件扩展为 size 字节的稀疏文件
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,103 @@
+// internal/downloader/request.go
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+)
+
+// rangeResponse 是 openRange 发出的一次请求的响应，用完后必须 Close
+type rangeResponse struct {
+	*http.Response
+	ctx      context.Context // 这次请求独立的 ctx，看门狗发现停滞时只取消这一次请求
+	cancel   context.CancelCauseFunc
+	watchdog *stallWatchdog
+}
+
+// openRange 向 target 发起一次 GET 请求，请求文件中 [start, end] 的数据
+// end 为负数时请求到文件末尾，start 为负数时不发送 Range；ifRange 不为空时随 Range 一起发送，
+// 远程文件已经变化时服务器会忽略 Range 返回完整的新文件 (200)，而不是新文件中的这一段
+// 206 的 Content-Range 必须从请求的位置开始，200 交给调用方判断是服务器忽略了 Range 还是文件已经变化，
+// 其他状态码返回 *statusError
+func (d *Downloader) openRange(ctx context.Context, target string, start, end int64, ifRange string) (*rangeResponse, error) {
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	r := &rangeResponse{ctx: attemptCtx, cancel: cancel, watchdog: newStallWatchdog(d.stallTimeout, cancel)}
+	resp, err := d.doRange(r, target, start, end, ifRange)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.Response = resp
+	return r, nil
+}
+
+// doRange 发送 openRange 的请求并检查响应的状态码，出错时关闭响应体
+func (d *Downloader) doRange(r *rangeResponse, target string, start, end int64, ifRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.applyHeader(req, target)
+	if start >= 0 {
+		if end >= 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+		}
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
+
+	d.stats.requests.Add(1)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, stallCause(r.ctx, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// 没有发送 Range 时服务器也可以返回 206，只要从文件开头开始就可以使用
+		first, last := max(start, 0), end
+		if last < 0 {
+			last = math.MaxInt64
+		}
+		if err := d.checkContentRange(resp.Header.Get("Content-Range"), first, last); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	case http.StatusOK:
+	default:
+		resp.Body.Close()
+		return nil, &statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return resp, nil
+}
+
+// body 返回读取响应体 src 的 ProgressReader，src 是响应体本身或者包装过的响应体
+// 读取的数据计入下载进度并受限速约束，长时间收不到数据时看门狗会取消这次请求
+func (d *Downloader) body(r *rangeResponse, src io.Reader) *ProgressReader {
+	return &ProgressReader{
+		Reader:     src,
+		onProgress: d.received,
+		watchdog:   r.watchdog,
+		limiter:    d.limiter(),
+		ctx:        r.ctx,
+	}
+}
+
+// err 把读取响应体时遇到的错误转换为请求失败的原因：被看门狗取消的请求返回 ErrStalled
+func (r *rangeResponse) err(err error) error {
+	return stallCause(r.ctx, err)
+}
+
+// Close 关闭响应体并结束这次请求
+func (r *rangeResponse) Close() {
+	if r.Response != nil {
+		r.Body.Close()
+	}
+	r.watchdog.stop()
+	r.cancel(nil)
+}
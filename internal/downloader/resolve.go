@@ -0,0 +1,143 @@
+// internal/downloader/resolve.go
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
+)
+
+// URLResolver 为过期的下载地址提供一个新的地址，例如重新生成预签名 URL
+type URLResolver interface {
+	// Resolve 返回 rawURL (提交下载时的原始 URL) 指向的同一个文件的新地址
+	Resolve(ctx context.Context, rawURL string) (string, error)
+}
+
+// SetURLResolver 设置下载地址失效 (401/403/410) 时获取新地址的方法
+// 没有设置时只能重新解析原始 URL 的重定向，原始 URL 本身过期后下载会失败
+func (d *Downloader) SetURLResolver(r URLResolver) {
+	d.resolver = r
+}
+
+// SetPinRedirects 设置是否固定重定向后的地址，默认开启
+// 开启时所有分片都直接请求 fileinfo.Get 解析出的 FinalURL，而不是各自跟随重定向，
+// 避免不同分片落到不同的 CDN 节点或拿到不同的签名 URL；该地址失效 (例如签名过期) 时重新解析一次
+func (d *Downloader) SetPinRedirects(pin bool) {
+	d.targetMu.Lock()
+	defer d.targetMu.Unlock()
+	d.pinRedirects = pin
+	d.resolved = d.source
+	if pin && d.finalURL != "" {
+		d.resolved = d.finalURL
+	}
+}
+
+// target 返回下载数据时请求的 URL
+func (d *Downloader) target() string {
+	d.targetMu.Lock()
+	defer d.targetMu.Unlock()
+	return d.resolved
+}
+
+// retryable 判断一次失败的请求是否值得重试
+// 下载地址返回 401/403/410 时先获取新的地址，得到新地址后重试；获取新地址本身失败时返回新的错误
+func (d *Downloader) retryable(ctx context.Context, err error, target string) (bool, error) {
+	if isRetryable(err) {
+		return true, err
+	}
+	if !isExpired(err) {
+		return false, err
+	}
+	ok, rerr := d.reresolve(ctx, target)
+	if rerr != nil {
+		return false, rerr
+	}
+	return ok, err
+}
+
+// isExpired 判断失败是否可能是因为签名 URL 已经过期
+func isExpired(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// reresolve 在 failed 地址失效时获取新的下载地址，返回是否得到了可以重试的新地址
+// 设置了 URLResolver 时由它提供新地址，否则重新解析原始 URL 的重定向
+// 多个分片同时失败时只获取一次，其余分片等待并共享结果；之后才失败的分片发现地址已经更新，直接使用新地址重试
+// 获取新地址时不持有 targetMu，其他分片 (包括从镜像下载的分片) 不会因此阻塞
+// 新地址指向的文件已经换成另一个版本时返回 ErrRemoteChanged
+func (d *Downloader) reresolve(ctx context.Context, failed string) (bool, error) {
+	d.targetMu.Lock()
+	resolved, source := d.resolved, d.source
+	d.targetMu.Unlock()
+	if resolved != failed {
+		return true, nil
+	}
+	// 没有经过重定向时，失效的就是原始 URL 本身，重新解析也无济于事
+	if d.resolver == nil && (!d.pinRedirects || resolved == source) {
+		return false, nil
+	}
+
+	ok, err, _ := d.resolveGroup.Do(failed, func() (any, error) {
+		source, err := d.freshSource(ctx)
+		if err != nil {
+			return false, err
+		}
+		info, err := fileinfo.Get(ctx, source, d.probeOptions()...)
+		if err != nil {
+			return false, fmt.Errorf("重新解析下载地址失败: %w", err)
+		}
+		if !d.sameVersion(info) {
+			return false, ErrRemoteChanged
+		}
+		next := source
+		if d.pinRedirects && info.FinalURL != "" {
+			next = info.FinalURL
+		}
+		if next == failed {
+			return false, nil
+		}
+		d.targetMu.Lock()
+		d.source, d.finalURL, d.resolved = source, info.FinalURL, next
+		d.targetMu.Unlock()
+		fmt.Println("\n🔁 下载地址已失效，已获取新的地址继续下载")
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok.(bool), nil
+}
+
+// freshSource 返回探测文件信息时请求的地址：设置了 URLResolver 时向它索取新地址，否则为原始 URL
+func (d *Downloader) freshSource(ctx context.Context) (string, error) {
+	if d.resolver == nil {
+		return d.url, nil
+	}
+	source, err := d.resolver.Resolve(ctx, d.url)
+	if err != nil {
+		return "", fmt.Errorf("无法获取新的下载地址: %w", err)
+	}
+	return source, nil
+}
+
+// sameVersion 判断 info 描述的是否是正在下载的那个版本的文件
+func (d *Downloader) sameVersion(info *fileinfo.Info) bool {
+	if info.Size != d.contentLen {
+		return false
+	}
+	if info.ETag != "" || d.etag != "" {
+		return info.ETag == d.etag
+	}
+	return info.LastModified == d.lastModified
+}
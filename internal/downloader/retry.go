@@ -0,0 +1,136 @@
+// internal/downloader/retry.go
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/Slade66/parallel-fetcher/internal/redact"
+)
+
+// RetryPolicy 定义了单个分片下载失败后的重试策略
+type RetryPolicy struct {
+	// MaxRetries 是第一次尝试失败后最多再重试的次数，0 表示不重试
+	MaxRetries int
+	// BaseDelay 是第一次重试前的基础等待时间，之后每次翻倍
+	BaseDelay time.Duration
+	// MaxDelay 是单次等待时间的上限
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy 返回默认的重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 5,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   30 * time.Second,
+	}
+}
+
+// backoff 计算第 attempt 次重试 (从 1 开始) 前的等待时间：指数退避 + 随机抖动
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt-1 < 32 {
+		if d := p.BaseDelay << (attempt - 1); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// 在 [delay/2, delay] 之间随机取值，避免所有分片在同一时刻重试
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// withRetry 反复调用 attempt 直到成功，按重试策略重试，返回尝试的次数和最后一次的错误
+// 每次尝试都重新选择来源：镜像出错或过慢时不再使用，立即换一个来源重试，不计入重试次数；
+// 原始地址的错误由 retryable 判断是否值得重试 (签名过期时先获取新地址)，指数退避后重试
+// attempt 的 ctx 在镜像过慢时以 errSlowMirror 为原因被取消，ifRange 是 target 所在服务器的 If-Range 请求头的值
+// label 返回重试前打印的进度行开头描述下载对象的文字 (包括结尾的空格)，received 返回目前收到的字节数，
+// 用于统计每个来源的速度。ctx 结束时返回 ctx.Err()
+func (d *Downloader) withRetry(ctx context.Context, label func() string, received func() int64,
+	attempt func(ctx context.Context, target, ifRange string) error) (int, error) {
+	var lastErr error
+	attempts := 0
+	switched := false // 上一次失败的是镜像，换一个来源立即重试
+	for attempts <= d.retry.MaxRetries {
+		if attempts > 0 && !switched {
+			delay := d.retry.backoff(attempts)
+			fmt.Printf("\n⚠️ %s下载出错: %s，%v 后进行第 %d 次重试\n", label(), redact.Text(lastErr.Error()), delay.Round(time.Millisecond), attempts)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return attempts, ctx.Err()
+			}
+		}
+		switched = false
+
+		attempts++
+		m := d.pickMirror()
+		target := d.mirrorTarget(m)
+		before := received()
+		started := time.Now()
+		mctx, stop := d.mirrorCtx(ctx, m, func() int64 { return received() - before })
+		lastErr = attempt(mctx, target, d.mirrorIfRange(m))
+		stop()
+		if errors.Is(context.Cause(mctx), errSlowMirror) {
+			lastErr = errSlowMirror
+		}
+		d.releaseMirror(m, max(received()-before, 0), time.Since(started), lastErr != nil && ctx.Err() == nil)
+		if lastErr == nil {
+			return attempts, nil
+		}
+		if ctx.Err() != nil {
+			return attempts, ctx.Err()
+		}
+		d.stats.errors.Add(1)
+		d.ctrl.observe(lastErr)
+		// 镜像出错时不再使用它，也不计入重试次数
+		if d.dropMirror(m, lastErr) {
+			attempts--
+			switched = true
+			continue
+		}
+		var retry bool
+		if retry, lastErr = d.retryable(ctx, lastErr, target); !retry {
+			break
+		}
+	}
+	return attempts, lastErr
+}
+
+// statusError 表示服务器返回了非预期的 HTTP 状态码
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("服务器返回了非预期的状态码: %s", e.Status)
+}
+
+// errRangeIgnored 表示服务器忽略了 Range 请求头，无法从断点继续
+var errRangeIgnored = errors.New("服务器忽略了 Range 请求头，返回了完整文件")
+
+// errBadContentRange 表示 206 响应的 Content-Range 与请求的字节范围不一致
+var errBadContentRange = errors.New("Content-Range 与请求的字节范围不一致")
+
+// isRetryable 判断一次失败的请求是否值得重试
+// 网络错误、连接被重置、5xx、408 和 429 会重试，其余 4xx 以及远程文件发生变化属于永久性错误
+func isRetryable(err error) bool {
+	if errors.Is(err, errRangeIgnored) || errors.Is(err, ErrRemoteChanged) {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500 ||
+			se.StatusCode == http.StatusRequestTimeout ||
+			se.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,238 @@
+// internal/downloader/scheduler.go
+package downloader
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinChunkSize 是默认的最小分片大小，剩余字节不足它的两倍时不再拆分
+	DefaultMinChunkSize int64 = 1 << 20
+	// DefaultMaxChunkSize 是默认的最大分片大小
+	DefaultMaxChunkSize int64 = 64 << 20
+	// minChunkFloor 是最小分片大小的下限
+	// 拆分点至少位于已写入位置之后 minChunkFloor 字节处，保证已读取未写入的数据加上
+	// 正在进行的一次读取 (各最多 32KB) 不会越过新的结束位置
+	minChunkFloor int64 = 128 << 10
+	// chunksPerThread 决定了初始切分时每个连接平均分到的分片数量
+	chunksPerThread = 4
+)
+
+// scheduler 负责把分片分配给空闲的连接
+// 分片队列为空时，空闲的连接会把预计最晚完成的进行中分片拆成两半，接手后半段 (work stealing)，
+// 这样一个慢连接不会拖住整个下载
+type scheduler struct {
+	mu       sync.Mutex
+	m        *manifest
+	pending  []int             // 尚未开始下载的分片序号
+	active   map[int]*partRate // 正在下载的分片序号及其速度
+	minSize  int64
+	canSplit bool
+}
+
+// partRate 记录进行中的分片从分配给连接以来写入的字节数，用于估计它还需要多久才能下载完
+// 重试的等待时间也计算在内，频繁出错的连接会显得更慢，更容易被分担
+type partRate struct {
+	started time.Time
+	bytes   int64
+}
+
+// speed 返回分片的下载速度 (字节/秒)，还没有收到数据时返回 0
+func (r *partRate) speed(now time.Time) float64 {
+	elapsed := now.Sub(r.started).Seconds()
+	if r.bytes == 0 || elapsed <= 0 {
+		return 0
+	}
+	return float64(r.bytes) / elapsed
+}
+
+// newScheduler 根据清单创建调度器，所有未完成的分片都会进入队列
+func newScheduler(m *manifest, minSize int64, canSplit bool) *scheduler {
+	s := &scheduler{
+		m:        m,
+		active:   make(map[int]*partRate),
+		minSize:  minSize,
+		canSplit: canSplit,
+	}
+	for i, p := range m.Parts {
+		if p.Completed < p.End-p.Start+1 {
+			s.pending = append(s.pending, i)
+		}
+	}
+	return s
+}
+
+// next 返回下一个需要下载的分片序号，没有可下载的分片时返回 false
+func (s *scheduler) next() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) > 0 {
+		i := s.pending[0]
+		s.pending = s.pending[1:]
+		s.active[i] = &partRate{started: time.Now()}
+		return i, true
+	}
+	if !s.canSplit {
+		return 0, false
+	}
+
+	victim, remaining := s.slowest(time.Now())
+	if victim < 0 {
+		return 0, false
+	}
+
+	// 从剩余范围的中点拆开，原分片缩短到中点之前，后半段成为新的分片
+	p := &s.m.Parts[victim]
+	mid := p.Start + p.Completed + remaining/2
+	stolen := manifestPart{Index: len(s.m.Parts), Start: mid, End: p.End}
+	p.End = mid - 1
+	s.m.Parts = append(s.m.Parts, stolen)
+	s.active[stolen.Index] = &partRate{started: time.Now()}
+	return stolen.Index, true
+}
+
+// slowest 返回剩余字节足够拆分的进行中分片中，预计剩余时间 (剩余字节/速度) 最长的一个及其剩余字节数，
+// 没有可以拆分的分片时返回 -1
+// 还没有收到数据的分片按其他分片的平均速度估计；都还没有数据时剩余字节最多的分片最晚完成
+func (s *scheduler) slowest(now time.Time) (int, int64) {
+	var total float64
+	measured := 0
+	for _, r := range s.active {
+		if v := r.speed(now); v > 0 {
+			total += v
+			measured++
+		}
+	}
+	avg := 1.0
+	if measured > 0 {
+		avg = total / float64(measured)
+	}
+
+	victim, victimRemaining, longest := -1, int64(0), -1.0
+	for i, r := range s.active {
+		p := s.m.Parts[i]
+		remaining := p.End - (p.Start + p.Completed) + 1
+		if remaining < 2*s.minSize {
+			continue
+		}
+		speed := r.speed(now)
+		if speed == 0 {
+			speed = avg
+		}
+		eta := float64(remaining) / speed
+		if eta > longest {
+			victim, victimRemaining, longest = i, remaining, eta
+		}
+	}
+	return victim, victimRemaining
+}
+
+// done 表示某个分片不再有连接在下载
+func (s *scheduler) done(i int) {
+	s.mu.Lock()
+	delete(s.active, i)
+	s.mu.Unlock()
+}
+
+// requeue 把没有下载完的分片放回队列头部，由下一个空闲的连接从断点继续
+func (s *scheduler) requeue(i int) {
+	s.mu.Lock()
+	delete(s.active, i)
+	s.pending = append([]int{i}, s.pending...)
+	s.mu.Unlock()
+}
+
+// state 返回分片当前的字节范围和已完成的字节数
+func (s *scheduler) state(i int) (part, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.m.Parts[i]
+	return part{index: p.Index, start: p.Start, end: p.End}, p.Completed
+}
+
+// setCompleted 设置分片已完成的字节数
+func (s *scheduler) setCompleted(i int, n int64) {
+	s.mu.Lock()
+	s.m.Parts[i].Completed = n
+	s.mu.Unlock()
+}
+
+// advance 增加分片已完成的字节数
+func (s *scheduler) advance(i int, n int64) {
+	s.mu.Lock()
+	s.m.Parts[i].Completed += n
+	if r, ok := s.active[i]; ok {
+		r.bytes += n
+	}
+	s.mu.Unlock()
+}
+
+// parts 返回按起始位置排序的全部分片
+func (s *scheduler) parts() []part {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := make([]part, len(s.m.Parts))
+	for i, p := range s.m.Parts {
+		parts[i] = part{index: p.Index, start: p.Start, end: p.End}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].start < parts[j].start })
+	return parts
+}
+
+// save 把当前的分片划分和进度写入清单
+// 先取快照再刷盘：快照中记录的字节在取快照前已经写入文件，刷盘后清单就不会超前于数据
+func (s *scheduler) save(st store) error {
+	s.mu.Lock()
+	data, err := s.m.encode()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := st.sync(); err != nil {
+		return err
+	}
+	return s.m.write(data)
+}
+
+// partReader 从响应体中读取分片数据，但不会越过分片当前的结束位置
+// 分片在下载途中可能被拆分，结束位置提前后读取会立即结束
+type partReader struct {
+	io.Reader
+	s   *scheduler
+	i   int
+	pos int64 // 下一个要读取的字节在文件中的位置
+}
+
+// Read 实现 io.Reader 接口
+func (r *partReader) Read(p []byte) (int, error) {
+	cur, _ := r.s.state(r.i)
+	allowed := cur.end - r.pos + 1
+	if allowed <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > allowed {
+		p = p[:allowed]
+	}
+	n, err := r.Reader.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// partWriter 把数据写入分片所在的文件，每次写入成功后才推进调度器中的进度
+// 进度只统计已经写入文件的字节，这样保存的清单不会超前于数据
+type partWriter struct {
+	w *io.OffsetWriter
+	s *scheduler
+	i int
+}
+
+// Write 实现 io.Writer 接口
+func (pw *partWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.s.advance(pw.i, int64(n))
+	return n, err
+}
@@ -0,0 +1,49 @@
+// internal/downloader/stats.go
+package downloader
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats 汇总了一次下载的传输情况，可以在 Run 返回后通过 Downloader.Stats 获取
+type Stats struct {
+	// Threads 是使用的连接数，自适应模式下为传输结束时的连接数
+	Threads int
+	// Bytes 是本次运行实际从服务器收到的字节数，不包括续传之前已经下载的部分
+	Bytes int64
+	// Duration 是传输阶段的耗时，不包括合并、校验和上传
+	Duration time.Duration
+	// Requests 是发出的分片请求数，包括重试
+	Requests int64
+	// Errors 是失败的分片请求数
+	Errors int64
+}
+
+// BytesPerSecond 返回传输阶段的平均速度
+func (s Stats) BytesPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// transferStats 在下载过程中累计传输情况，各字段可以被多个连接并发更新
+type transferStats struct {
+	bytes    atomic.Int64
+	requests atomic.Int64
+	errors   atomic.Int64
+	threads  int
+	duration time.Duration
+}
+
+// Stats 返回最近一次 Run 的传输情况
+func (d *Downloader) Stats() Stats {
+	return Stats{
+		Threads:  d.stats.threads,
+		Bytes:    d.stats.bytes.Load(),
+		Duration: d.stats.duration,
+		Requests: d.stats.requests.Load(),
+		Errors:   d.stats.errors.Load(),
+	}
+}
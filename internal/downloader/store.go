@@ -0,0 +1,361 @@
+// internal/downloader/store.go
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WriteMode 决定了分片数据的落盘方式
+type WriteMode string
+
+const (
+	// WriteParts 每个分片写入独立的文件，全部下载完成后再合并为一个文件
+	WriteParts WriteMode = "parts"
+	// WriteDirect 预先分配最终文件的空间，每个分片直接写到自己的偏移位置，省去合并这一步
+	// 磁盘 I/O 和所需的空闲空间都只有 WriteParts 的一半
+	WriteDirect WriteMode = "direct"
+)
+
+// store 抽象了分片数据的存放位置
+type store interface {
+	// openPart 返回用于写入分片 p 的文件，以及分片第一个字节在该文件中的偏移
+	// fresh 表示分片还没有下载过任何字节，此时分片中残留的旧数据会被丢弃
+	openPart(p part, fresh bool) (*os.File, int64, error)
+	// closePart 释放 openPart 返回的文件
+	closePart(f *os.File) error
+	// restore 在续传前校正调度器中记录的进度，返回已下载的总字节数
+	restore(s *scheduler) int64
+	// sync 把已经写入的数据刷到磁盘，必须在保存清单之前调用
+	sync() error
+	// verify 检查每个分片是否已经完整写入
+	verify(s *scheduler) []*PartError
+	// assemble 生成包含完整文件内容的本地文件，返回其路径
+	// h 不为 nil 时，文件的全部内容会按顺序写入 h 以计算摘要
+	assemble(parts []part, h hash.Hash) (string, error)
+	// contents 返回按文件中的绝对偏移读写已下载数据的 contentFile，用于逐块校验和重新下载损坏的块
+	contents(parts []part) (contentFile, error)
+	// close 释放存储持有的资源，可以重复调用
+	close() error
+}
+
+// contentFile 按文件中的绝对偏移读写已下载的数据，用完后必须 Close
+type contentFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Close() error
+}
+
+// partStore 把每个分片保存为临时目录中的独立文件，分片文件的大小就是该分片的进度
+type partStore struct {
+	dir string
+}
+
+func (ps *partStore) path(p part) string {
+	return filepath.Join(ps.dir, fmt.Sprintf("part-%d", p.index))
+}
+
+// openPart 打开分片文件，fresh 时清空文件
+// 拆分出的分片在保存清单前进程就崩溃时，分片文件会留在磁盘上而清单中没有它，续传时同一序号可能被重新使用
+func (ps *partStore) openPart(p part, fresh bool) (*os.File, int64, error) {
+	flag := os.O_CREATE | os.O_WRONLY
+	if fresh {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(ps.path(p), flag, 0644)
+	return f, 0, err
+}
+
+// prune 删除清单中没有记录的分片文件，它们来自崩溃前还没来得及保存到清单中的拆分
+func (ps *partStore) prune(m *manifest) error {
+	known := make(map[int]bool, len(m.Parts))
+	for _, p := range m.Parts {
+		known[p.Index] = true
+	}
+	paths, err := filepath.Glob(filepath.Join(ps.dir, "part-*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		index, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "part-"))
+		if err == nil && known[index] {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *partStore) closePart(f *os.File) error {
+	return f.Close()
+}
+
+// restore 以分片文件的实际大小为准校正进度
+// 清单是定期保存的，进程崩溃时它可能落后于分片文件，而分片文件中的数据总是可信的
+func (ps *partStore) restore(s *scheduler) int64 {
+	var total int64
+	for _, p := range s.parts() {
+		info, err := os.Stat(ps.path(p))
+		if err != nil {
+			s.setCompleted(p.index, 0)
+			continue
+		}
+		completed := info.Size()
+		if completed > p.size() {
+			os.Truncate(ps.path(p), p.size())
+			completed = p.size()
+		}
+		s.setCompleted(p.index, completed)
+		total += completed
+	}
+	return total
+}
+
+func (ps *partStore) sync() error {
+	return nil
+}
+
+// verify 检查每个分片文件的字节数是否与其字节范围一致
+// 空文件唯一的分片 [0, -1] 不会被下载，也就没有分片文件，视为已经完成
+func (ps *partStore) verify(s *scheduler) []*PartError {
+	var errs []*PartError
+	for _, p := range s.parts() {
+		if p.size() == 0 {
+			continue
+		}
+		info, err := os.Stat(ps.path(p))
+		if err != nil {
+			errs = append(errs, newPartError(p, 0, err))
+			continue
+		}
+		if info.Size() != p.size() {
+			errs = append(errs, newPartError(p, 0, fmt.Errorf("%w: 期望 %d 字节，实际 %d 字节", ErrPartIncomplete, p.size(), info.Size())))
+		}
+	}
+	return errs
+}
+
+// assemble 按起始位置依次把所有分片合并到一个临时文件中，摘要在合并的同时计算
+func (ps *partStore) assemble(parts []part, h hash.Hash) (string, error) {
+	mergedFile, err := os.CreateTemp(ps.dir, "merged-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("创建临时合并文件失败: %w", err)
+	}
+	defer mergedFile.Close()
+	var w io.Writer = mergedFile
+	if h != nil {
+		w = io.MultiWriter(mergedFile, h)
+	}
+
+	for _, p := range parts {
+		if p.size() == 0 {
+			continue
+		}
+		partPath := ps.path(p)
+		partFile, err := os.Open(partPath)
+		if err != nil {
+			// 如果某个分片不存在，可能意味着该分片下载失败，应返回错误
+			os.Remove(mergedFile.Name())
+			return "", fmt.Errorf("无法打开分片文件 %s: %w", partPath, err)
+		}
+		_, err = io.Copy(w, partFile)
+		partFile.Close() // 及时关闭文件句柄
+		if err != nil {
+			os.Remove(mergedFile.Name())
+			return "", fmt.Errorf("合并分片 %s 失败: %w", partPath, err)
+		}
+	}
+	return mergedFile.Name(), nil
+}
+
+// contents 把各个分片文件拼接成一个可以按绝对偏移读写的文件
+func (ps *partStore) contents(parts []part) (contentFile, error) {
+	return &partsFile{ps: ps, parts: parts, files: make(map[int]*os.File)}, nil
+}
+
+func (ps *partStore) close() error {
+	return nil
+}
+
+// partsFile 把按起始位置排列的分片文件拼接成一个 contentFile，打开的分片文件在 Close 时关闭
+// 可以被多个 goroutine 同时读写
+type partsFile struct {
+	ps    *partStore
+	parts []part
+	mu    sync.Mutex
+	files map[int]*os.File
+}
+
+// ReadAt 实现 io.ReaderAt 接口
+func (pf *partsFile) ReadAt(b []byte, off int64) (int, error) {
+	return pf.each(b, off, func(f *os.File, b []byte, off int64) (int, error) { return f.ReadAt(b, off) })
+}
+
+// WriteAt 实现 io.WriterAt 接口
+func (pf *partsFile) WriteAt(b []byte, off int64) (int, error) {
+	return pf.each(b, off, func(f *os.File, b []byte, off int64) (int, error) { return f.WriteAt(b, off) })
+}
+
+// each 把 [off, off+len(b)) 按分片拆开，依次对每个分片文件中对应的部分调用 fn
+func (pf *partsFile) each(b []byte, off int64, fn func(f *os.File, b []byte, off int64) (int, error)) (int, error) {
+	done := 0
+	for done < len(b) {
+		pos := off + int64(done)
+		i := sort.Search(len(pf.parts), func(i int) bool { return pf.parts[i].end >= pos })
+		if i == len(pf.parts) || pf.parts[i].start > pos {
+			return done, io.EOF
+		}
+		p := pf.parts[i]
+		f, err := pf.file(p)
+		if err != nil {
+			return done, err
+		}
+		chunk := b[done:min(len(b), done+int(p.end-pos+1))]
+		n, err := fn(f, chunk, pos-p.start)
+		done += n
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// file 返回分片 p 的文件，第一次使用时打开
+func (pf *partsFile) file(p part) (*os.File, error) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if f, ok := pf.files[p.index]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(pf.ps.path(p), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	pf.files[p.index] = f
+	return f, nil
+}
+
+// Close 关闭所有打开的分片文件
+func (pf *partsFile) Close() error {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	var first error
+	for _, f := range pf.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	pf.files = nil
+	return first
+}
+
+// directStore 把所有分片写入同一个预分配好的文件
+// 预分配的文件大小与进度无关，因此进度完全以清单为准：保存清单前必须先 sync
+type directStore struct {
+	path string
+	file *os.File
+}
+
+// openDirectStore 打开直接写入模式的数据文件，fresh 为 true 时清空并重新预分配空间
+func openDirectStore(path string, size int64, fresh bool) (*directStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建输出文件: %w", err)
+	}
+	if fresh {
+		if err := f.Truncate(0); err == nil {
+			err = preallocate(f, size)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("无法为输出文件预分配空间: %w", err)
+		}
+	}
+	return &directStore{path: path, file: f}, nil
+}
+
+func (ds *directStore) openPart(p part, _ bool) (*os.File, int64, error) {
+	return ds.file, p.start, nil
+}
+
+// closePart 不关闭共享的数据文件
+func (ds *directStore) closePart(*os.File) error {
+	return nil
+}
+
+// restore 直接使用清单中记录的进度
+func (ds *directStore) restore(s *scheduler) int64 {
+	var total int64
+	for _, p := range s.parts() {
+		_, completed := s.state(p.index)
+		total += completed
+	}
+	return total
+}
+
+func (ds *directStore) sync() error {
+	return ds.file.Sync()
+}
+
+// verify 检查清单中每个分片的进度是否与其字节范围一致
+func (ds *directStore) verify(s *scheduler) []*PartError {
+	var errs []*PartError
+	for _, p := range s.parts() {
+		if _, completed := s.state(p.index); completed != p.size() {
+			errs = append(errs, newPartError(p, 0, fmt.Errorf("%w: 期望 %d 字节，实际 %d 字节", ErrPartIncomplete, p.size(), completed)))
+		}
+	}
+	return errs
+}
+
+// assemble 数据已经在最终位置上，只需要刷盘并关闭文件
+// 分片是乱序写入的，需要计算摘要时只能在最后把整个文件顺序读一遍
+func (ds *directStore) assemble(_ []part, h hash.Hash) (string, error) {
+	if err := ds.file.Sync(); err != nil {
+		return "", err
+	}
+	if h != nil {
+		if _, err := io.Copy(h, io.NewSectionReader(ds.file, 0, math.MaxInt64)); err != nil {
+			return "", fmt.Errorf("计算文件摘要失败: %w", err)
+		}
+	}
+	if err := ds.close(); err != nil {
+		return "", err
+	}
+	return ds.path, nil
+}
+
+// contents 直接使用数据文件
+func (ds *directStore) contents([]part) (contentFile, error) {
+	return sharedFile{ds.file}, nil
+}
+
+// sharedFile 是不会被 Close 关闭的数据文件，由 directStore 负责关闭
+type sharedFile struct {
+	*os.File
+}
+
+// Close 不关闭共享的数据文件
+func (sharedFile) Close() error {
+	return nil
+}
+
+func (ds *directStore) close() error {
+	if ds.file == nil {
+		return nil
+	}
+	err := ds.file.Close()
+	ds.file = nil
+	return err
+}
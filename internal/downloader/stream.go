@@ -0,0 +1,112 @@
+// internal/downloader/stream.go
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runStream 用单个连接把大小未知的响应体顺序写入文件
+// 文件大小未知时无法切分分片，也无法校验续传的进度，因此不使用下载清单：
+// 中途失败时，服务器支持 Range 的话从已写入的位置继续，否则从头重新下载
+func (d *Downloader) runStream(ctx context.Context) error {
+	tempDir, _ := d.workPaths()
+	if err := os.RemoveAll(tempDir); err != nil {
+		return fmt.Errorf("无法清理临时目录: %w", err)
+	}
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return fmt.Errorf("无法创建临时目录: %w", err)
+	}
+	filePath := d.directPath(tempDir)
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("无法创建输出文件: %w", err)
+	}
+	abort := func() {
+		file.Close()
+		os.Remove(filePath)
+		d.cleanup(tempDir)
+	}
+	fmt.Println("文件大小未知，使用单个连接顺序下载...")
+	d.notifyConcurrency(1)
+
+	var h hash.Hash
+	if !d.checksum.IsZero() {
+		h = d.checksum.NewHash()
+	}
+
+	started := time.Now()
+	var written int64
+	attempts, lastErr := d.withRetry(ctx,
+		func() string { return "" },
+		func() int64 { return written },
+		func(ctx context.Context, target, ifRange string) (err error) {
+			written, err = d.fetchStream(ctx, file, written, &h, target, ifRange)
+			return err
+		})
+	d.stats.duration = time.Since(started)
+	d.stats.threads = 1
+	if ctx.Err() != nil {
+		abort()
+		return fmt.Errorf("下载已取消: %w", ctx.Err())
+	}
+	if lastErr != nil {
+		abort()
+		return &DownloadError{Parts: []*PartError{newPartError(part{index: 0, start: 0, end: written - 1}, attempts, lastErr)}}
+	}
+
+	if err := file.Close(); err != nil {
+		abort()
+		return err
+	}
+	d.contentLen = written
+	fmt.Printf("\n文件下载完成，共 %.2f MB\n", float64(written)/1024/1024)
+	if err := d.publish(ctx, tempDir, filePath, h); err != nil {
+		// 没有清单可以续传，失败后不保留任何本地数据
+		os.Remove(filePath)
+		d.cleanup(tempDir)
+		return fmt.Errorf("保存或上传文件失败: %w", err)
+	}
+	return nil
+}
+
+// fetchStream 向 target 发起一次请求，把响应体追加到 file 中已写入的 written 字节之后，返回写入后的总字节数
+// 服务器没有从 written 处继续时从头重新写入，*h 也会随之重置
+func (d *Downloader) fetchStream(ctx context.Context, file *os.File, written int64, h *hash.Hash, target, ifRange string) (int64, error) {
+	start := int64(-1)
+	if d.acceptsRanges && written > 0 {
+		start = written
+	}
+	resp, err := d.openRange(ctx, target, start, -1, ifRange)
+	if err != nil {
+		return written, err
+	}
+	defer resp.Close()
+	if resp.StatusCode == http.StatusOK && written > 0 {
+		// 服务器返回了整个文件 (不支持 Range，或者 If-Range 发现文件已经变化)，丢弃已写入的数据从头开始
+		d.Notify(-written)
+		if err := file.Truncate(0); err != nil {
+			return written, err
+		}
+		written = 0
+		if *h != nil {
+			(*h).Reset()
+		}
+	}
+
+	var w io.Writer = io.NewOffsetWriter(file, written)
+	if *h != nil {
+		w = io.MultiWriter(w, *h)
+	}
+	n, err := io.Copy(w, d.body(resp, resp.Body))
+	written += n
+	if err != nil {
+		return written, resp.err(err)
+	}
+	return written, nil
+}
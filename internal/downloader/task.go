@@ -2,84 +2,176 @@
 package downloader
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath" // 新增：导入 filepath
+	"strings"
+
+	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
 )
 
-// downloadPart 下载单个文件分片 (此函数保持不变)
-func (d *Downloader) downloadPart(partPath string, start, end int64) error {
-	req, err := http.NewRequest("GET", d.url, nil)
+// downloadPart 下载单个文件分片，失败时按照重试策略进行指数退避重试
+// 每次重试都会从分片文件中已写入的字节处继续，而不是从头开始
+// 下载进度会同步记录到调度器中，最终失败时返回 *PartError
+func (d *Downloader) downloadPart(ctx context.Context, s *scheduler, i int, st store) error {
+	p, completed := s.state(i)
+	file, offset, err := st.openPart(p, completed == 0)
 	if err != nil {
-		return err
+		return newPartError(p, 0, err)
 	}
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	defer st.closePart(file)
 
-	resp, err := d.client.Do(req)
+	attempts, err := d.withRetry(ctx,
+		func() string {
+			p, _ := s.state(i)
+			return fmt.Sprintf("分片 %d [%d-%d] ", p.index, p.start, p.end)
+		},
+		func() int64 {
+			_, written := s.state(i)
+			return written
+		},
+		func(ctx context.Context, target, ifRange string) error {
+			return d.fetchRange(ctx, s, i, file, offset, target, ifRange)
+		})
 	if err != nil {
-		return err
+		p, _ = s.state(i)
+		return newPartError(p, attempts, err)
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("服务器返回了非预期的状态码: %s", resp.Status)
+// fetchRange 向 target 发起一次 HTTP 请求，把分片中尚未下载的部分写入 file 中 offset 开始的位置
+// 分片被拆分后只下载到新的结束位置为止，ifRange 是 target 所在服务器的 If-Range 请求头的值
+func (d *Downloader) fetchRange(ctx context.Context, s *scheduler, i int, file *os.File, offset int64, target, ifRange string) error {
+	p, written := s.state(i)
+	// 服务器不支持 Range 时无法续传，只能从头下载并覆盖已写入的数据
+	if !d.acceptsRanges && written > 0 {
+		// 撤销已经汇报过的进度
+		d.Notify(-written)
+		s.setCompleted(i, 0)
+		written = 0
+	}
+	if written >= p.size() {
+		return nil
 	}
 
-	file, err := os.Create(partPath)
+	start := int64(-1)
+	if d.acceptsRanges {
+		start = p.start + written
+	}
+	resp, err := d.openRange(ctx, target, start, p.end, ifRange)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer resp.Close()
+	// 探测时服务器支持 Range，现在却对 Range 请求返回了完整文件，说明 If-Range 没有通过：
+	// 文件已经换成了另一个版本，已下载的分片都不能再使用
+	if resp.StatusCode == http.StatusOK && d.acceptsRanges {
+		return ErrRemoteChanged
+	}
 
-	progressReader := &ProgressReader{
-		Reader:     resp.Body,
-		onProgress: d.Notify,
+	reader := d.body(resp, &partReader{Reader: resp.Body, s: s, i: i, pos: p.start + written})
+	writer := &partWriter{w: io.NewOffsetWriter(file, offset+written), s: s, i: i}
+	if _, err := io.Copy(writer, reader); err != nil {
+		return resp.err(err)
 	}
+	// 响应体提前结束而分片还没下载完，交给重试逻辑从断点继续
+	if p, written = s.state(i); written < p.size() {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 
-	_, err = io.Copy(file, progressReader)
+// ifRangeValue 返回 If-Range 请求头的值：优先使用强 ETag，否则使用 Last-Modified，都没有时返回空字符串
+// 弱 ETag 不能用于 If-Range，服务器总是会认为它不匹配
+func ifRangeValue(etag, lastModified string) string {
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return lastModified
+}
+
+// checkContentRange 校验 206 响应的 Content-Range：起始位置必须等于请求的 start，结束位置不能超过 end，
+// 文件总大小与下载开始时不一致则说明远程文件已经变化
+func (d *Downloader) checkContentRange(contentRange string, start, end int64) error {
+	first, last, total, err := fileinfo.ParseContentRange(contentRange)
+	if err != nil {
+		return err
+	}
+	// 文件大小未知 (流式下载) 时无法比较总大小
+	if total >= 0 && d.contentLen >= 0 && total != d.contentLen {
+		return fmt.Errorf("%w: 文件大小从 %d 变为 %d", ErrRemoteChanged, d.contentLen, total)
+	}
+	// 服务器可以返回比请求更短的范围，剩余部分由重试逻辑继续下载
+	if first != start || last > end {
+		return fmt.Errorf("%w: 请求 %d-%d，收到 %q", errBadContentRange, start, end, contentRange)
+	}
+	return nil
+}
+
+// stallCause 如果请求是被看门狗取消的，返回 ErrStalled，否则原样返回 err
+func stallCause(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrStalled) {
+		return ErrStalled
+	}
 	return err
 }
 
-// mergeAndUpload 合并所有分片到临时文件，然后上传，最后清理
-func (d *Downloader) mergeAndUpload(tempDir string) error {
-	// 1. 创建一个临时的、用于合并的大文件
-	mergedFile, err := os.CreateTemp(tempDir, "merged-*.tmp")
+// finalize 生成完整的文件后，保存到输出路径或上传到 OBS，最后清理临时文件
+// 失败时分片和清单会被保留，以便下次直接从这一步重来
+func (d *Downloader) finalize(ctx context.Context, tempDir string, st store, parts []part) error {
+	var h hash.Hash
+	if !d.checksum.IsZero() {
+		h = d.checksum.NewHash()
+	}
+	filePath, err := st.assemble(parts, h)
 	if err != nil {
-		return fmt.Errorf("创建临时合并文件失败: %w", err)
+		return err
 	}
-	defer mergedFile.Close() // 确保临时文件最终被关闭
+	return d.publish(ctx, tempDir, filePath, h)
+}
 
-	// 2. 依次将所有分片文件写入这个临时文件
-	for i := 0; i < d.threads; i++ {
-		partPath := fmt.Sprintf("%s/part-%d", tempDir, i)
-		partFile, err := os.Open(partPath)
-		if err != nil {
-			// 如果某个分片不存在，可能意味着该分片下载失败，应返回错误
-			// 也需要在函数退出时清理临时目录
-			os.RemoveAll(tempDir)
-			return fmt.Errorf("无法打开分片文件 %s: %w", partPath, err)
+// publish 校验完整的文件后把它保存到输出路径或上传到 OBS，成功后清理临时文件
+// h 是按顺序写入了文件全部内容的摘要，没有期望摘要时为 nil
+// 文件与期望摘要不一致时返回 *checksum.MismatchError，并丢弃全部本地数据，不会保存或上传
+func (d *Downloader) publish(ctx context.Context, tempDir, filePath string, h hash.Hash) error {
+	if h != nil {
+		if err := d.checksum.Verify(h.Sum(nil)); err != nil {
+			os.Remove(filePath)
+			d.discard(tempDir)
+			return err
 		}
-		_, err = io.Copy(mergedFile, partFile)
-		partFile.Close() // 及时关闭文件句柄
-		if err != nil {
-			os.RemoveAll(tempDir)
-			return fmt.Errorf("合并分片 %s 失败: %w", partPath, err)
+		fmt.Printf("🔐 文件校验通过 (%s)\n", d.checksum.Algorithm)
+	}
+
+	// 没有配置上传器时 (例如命令行模式)，直接把文件移动到输出路径
+	if d.uploader == nil {
+		if err := moveFile(filePath, d.output); err != nil {
+			return fmt.Errorf("保存文件到 %s 失败: %w", d.output, err)
 		}
+		return d.cleanup(tempDir)
 	}
 
-	// 3. 上传这个合并好的临时文件到 OBS
-	// 我们使用 d.output 作为在 OBS 中的对象键 (Object Key)
+	// 上传到 OBS
+	// 没有指定对象键时使用 d.output 中的文件名作为在 OBS 中的对象键 (Object Key)
 	// 使用 filepath.Base 可以去掉路径，只保留文件名
-	objectKey := filepath.Base(d.output)
-	if err := d.uploader.UploadFile(objectKey, mergedFile.Name()); err != nil {
-		// 上传失败也需要清理临时目录
-		os.RemoveAll(tempDir)
+	objectKey := d.objectKey
+	if objectKey == "" {
+		objectKey = filepath.Base(d.output)
+	}
+	if err := d.uploader.UploadFile(ctx, objectKey, filePath); err != nil {
+		// 合并产生的临时文件可以重新生成，直接删除以释放空间
+		if d.writeMode == WriteParts {
+			os.Remove(filePath)
+		}
 		return err
 	}
 
-	// 4. 清理所有本地临时文件
-	// os.RemoveAll 会删除整个 tempDir 文件夹，包括里面的所有分片和合并后的临时文件
-	return os.RemoveAll(tempDir)
+	// 清理所有本地临时文件和下载清单
+	return d.cleanup(tempDir)
 }
@@ -1,19 +1,103 @@
 // internal/downloader/util.go
 package downloader
 
-import "io"
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Slade66/parallel-fetcher/internal/ratelimit"
+)
+
+// ErrStalled 表示连接在设定的时间窗口内没有收到任何数据
+var ErrStalled = errors.New("连接停滞: 长时间没有收到任何数据")
 
 // ProgressReader 用于包装 io.Reader 来跟踪进度
+// 设置了 watchdog 时，每次读到数据都会重置它；设置了 limiter 时，每次读到数据后按限速等待
 type ProgressReader struct {
 	io.Reader
 	onProgress func(int64)
+	watchdog   *stallWatchdog
+	limiter    ratelimit.Limiter
+	ctx        context.Context
 }
 
 // Read 实现 io.Reader 接口
 func (pr *ProgressReader) Read(p []byte) (n int, err error) {
 	n, err = pr.Reader.Read(p)
 	if n > 0 {
+		pr.watchdog.reset()
 		pr.onProgress(int64(n))
+		if pr.limiter != nil {
+			// 限速等待的时间不是服务器造成的，不计入停滞检测
+			pr.watchdog.stop()
+			if werr := pr.limiter.WaitN(pr.ctx, n); werr != nil && err == nil {
+				err = werr
+			}
+			pr.watchdog.reset()
+		}
 	}
 	return
 }
+
+// stallWatchdog 在超过 window 没有收到数据时以 ErrStalled 为原因取消请求
+// 与整体超时不同，只要数据还在流动，传输多久都不会被中断
+type stallWatchdog struct {
+	timer  *time.Timer
+	window time.Duration
+}
+
+// newStallWatchdog 启动一个看门狗，window 为 0 时返回 nil (不检测停滞)
+func newStallWatchdog(window time.Duration, cancel context.CancelCauseFunc) *stallWatchdog {
+	if window <= 0 {
+		return nil
+	}
+	return &stallWatchdog{
+		timer:  time.AfterFunc(window, func() { cancel(ErrStalled) }),
+		window: window,
+	}
+}
+
+// reset 重新开始计时
+func (w *stallWatchdog) reset() {
+	if w != nil {
+		w.timer.Reset(w.window)
+	}
+}
+
+// stop 停止看门狗
+func (w *stallWatchdog) stop() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
+
+// moveFile 将文件移动到目标路径
+// 临时目录与目标路径可能不在同一个文件系统上，此时 os.Rename 会失败，退化为复制后删除
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
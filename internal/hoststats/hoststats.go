@@ -0,0 +1,185 @@
+// internal/hoststats/hoststats.go
+package hoststats
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// hostsKey 是记录所有出现过的主机的集合
+	hostsKey = "host:list"
+	// minSamples 是一个线程数参与推荐所需的最少样本数
+	minSamples = 3
+	// exploreRate 是推荐时改为尝试相邻线程数的概率，否则只会一直使用已知最好的线程数，学不到更好的值
+	exploreRate = 0.1
+)
+
+// Sample 是一次已完成的下载的传输情况
+type Sample struct {
+	Threads  int
+	Bytes    int64
+	Duration time.Duration
+	Requests int64
+	Errors   int64
+}
+
+// Entry 汇总了使用某个线程数下载某台主机上的文件的表现
+type Entry struct {
+	Threads        int     `json:"threads"`
+	Samples        int64   `json:"samples"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	ErrorRate      float64 `json:"error_rate"`
+}
+
+// score 是推荐线程数时使用的评分：扣除出错比例后的吞吐量
+func (e Entry) score() float64 {
+	return e.BytesPerSecond * (1 - e.ErrorRate)
+}
+
+// HostStats 是一台主机的全部统计数据
+type HostStats struct {
+	Host        string  `json:"host"`
+	Recommended int     `json:"recommended_threads,omitempty"` // 样本不足时为 0
+	Entries     []Entry `json:"entries"`
+}
+
+// Store 把每台主机的下载表现保存在 Redis 中，供后续的任务选择线程数
+// 每台主机一个 Hash，字段为 "<线程数>:<指标>"，所有指标都是累加值，多个 Worker 可以同时写入
+type Store struct {
+	rdb *redis.Client
+}
+
+// NewStore 创建一个新的统计存储
+func NewStore(rdb *redis.Client) *Store {
+	return &Store{rdb: rdb}
+}
+
+// hostKey 返回一台主机的统计数据在 Redis 中的键名
+func (s *Store) hostKey(host string) string {
+	return fmt.Sprintf("host:stats:%s", host)
+}
+
+// HostOf 返回 URL 中的主机 (包含端口)，统计数据按它归类
+func HostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// Record 记录一次已完成的下载
+func (s *Store) Record(ctx context.Context, host string, sample Sample) error {
+	if host == "" || sample.Threads <= 0 || sample.Duration <= 0 {
+		return nil
+	}
+	key := s.hostKey(host)
+	prefix := strconv.Itoa(sample.Threads) + ":"
+	pipe := s.rdb.TxPipeline()
+	pipe.SAdd(ctx, hostsKey, host)
+	pipe.HIncrBy(ctx, key, prefix+"samples", 1)
+	pipe.HIncrBy(ctx, key, prefix+"bytes", sample.Bytes)
+	pipe.HIncrBy(ctx, key, prefix+"millis", max(1, sample.Duration.Milliseconds()))
+	pipe.HIncrBy(ctx, key, prefix+"requests", sample.Requests)
+	pipe.HIncrBy(ctx, key, prefix+"errors", sample.Errors)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Get 返回一台主机的统计数据
+func (s *Store) Get(ctx context.Context, host string) (*HostStats, error) {
+	data, err := s.rdb.HGetAll(ctx, s.hostKey(host)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[int]map[string]int64)
+	for field, value := range data {
+		threadsStr, metric, ok := strings.Cut(field, ":")
+		threads, err := strconv.Atoi(threadsStr)
+		if !ok || err != nil {
+			continue
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		if sums[threads] == nil {
+			sums[threads] = make(map[string]int64)
+		}
+		sums[threads][metric] = n
+	}
+
+	hs := &HostStats{Host: host, Entries: make([]Entry, 0, len(sums))}
+	for threads, m := range sums {
+		e := Entry{Threads: threads, Samples: m["samples"]}
+		if m["millis"] > 0 {
+			e.BytesPerSecond = float64(m["bytes"]) / (float64(m["millis"]) / 1000)
+		}
+		if m["requests"] > 0 {
+			e.ErrorRate = float64(m["errors"]) / float64(m["requests"])
+		}
+		hs.Entries = append(hs.Entries, e)
+	}
+	sort.Slice(hs.Entries, func(i, j int) bool { return hs.Entries[i].Threads < hs.Entries[j].Threads })
+	hs.Recommended = hs.best()
+	return hs, nil
+}
+
+// best 返回样本足够的线程数中评分最高的一个，没有时返回 0
+func (hs *HostStats) best() int {
+	best, bestScore := 0, 0.0
+	for _, e := range hs.Entries {
+		if e.Samples >= minSamples && e.score() > bestScore {
+			best, bestScore = e.Threads, e.score()
+		}
+	}
+	return best
+}
+
+// All 返回所有主机的统计数据
+func (s *Store) All(ctx context.Context) ([]HostStats, error) {
+	hosts, err := s.rdb.SMembers(ctx, hostsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(hosts)
+	all := make([]HostStats, 0, len(hosts))
+	for _, host := range hosts {
+		hs, err := s.Get(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *hs)
+	}
+	return all, nil
+}
+
+// Suggest 为下载某台主机上的文件推荐线程数，结果在 [1, limit] 之间
+// 通常返回样本足够的线程数中表现最好的一个；偶尔会尝试它的一半或 1.5 倍，以便发现更好的值。
+// 该主机的样本还不够时返回 fallback
+func (s *Store) Suggest(ctx context.Context, host string, fallback, limit int) (int, error) {
+	hs, err := s.Get(ctx, host)
+	if err != nil {
+		return fallback, err
+	}
+	threads := hs.Recommended
+	if threads == 0 {
+		// 样本不足时也可能已经试过其他线程数，继续积累 fallback 的样本
+		return fallback, nil
+	}
+	if rand.Float64() < exploreRate {
+		if rand.Intn(2) == 0 {
+			threads = threads / 2
+		} else {
+			threads = threads + max(1, threads/2)
+		}
+	}
+	return max(1, min(threads, limit)), nil
+}
@@ -6,6 +6,17 @@ type Observer interface {
 	Update(downloaded int64)
 }
 
+// ConcurrencyObserver 是可选的观察者接口，实现了它的观察者会在下载使用的连接数变化时收到通知
+type ConcurrencyObserver interface {
+	UpdateConcurrency(connections int)
+}
+
+// RestartObserver 是可选的观察者接口，实现了它的观察者会在远程文件发生变化、下载从头开始时收到通知
+// totalSize 是新版本文件的大小，未知时为负数
+type RestartObserver interface {
+	Restart(totalSize int64)
+}
+
 // Observable 被观察者（主题）接口
 type Observable interface {
 	AddObserver(o Observer)
@@ -11,11 +11,12 @@ import (
 type ProgressBarObserver struct {
 	total    int64
 	current  int64
+	conns    int // 当前使用的连接数，0 表示未知
 	barWidth int
 	mu       sync.Mutex
 }
 
-// NewProgressBarObserver 创建一个新的进度条观察者
+// NewProgressBarObserver 创建一个新的进度条观察者，totalSize 小于等于 0 表示文件大小未知
 func NewProgressBarObserver(totalSize int64) *ProgressBarObserver {
 	return &ProgressBarObserver{
 		total:    totalSize,
@@ -31,10 +32,33 @@ func (p *ProgressBarObserver) Update(downloaded int64) {
 	p.print()
 }
 
+// UpdateConcurrency 实现了 ConcurrencyObserver 接口，在进度条后面显示连接数
+func (p *ProgressBarObserver) UpdateConcurrency(connections int) {
+	p.mu.Lock()
+	p.conns = connections
+	p.mu.Unlock()
+}
+
+// Restart 实现了 RestartObserver 接口，清空进度并使用新的文件大小
+func (p *ProgressBarObserver) Restart(totalSize int64) {
+	p.mu.Lock()
+	p.total = totalSize
+	p.current = 0
+	p.mu.Unlock()
+}
+
 // print 在终端上绘制进度条 (代码不变)
 func (p *ProgressBarObserver) print() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	// 文件大小未知时无法计算百分比，只显示已下载的数据量
+	if p.total <= 0 {
+		fmt.Printf("\r已下载 %.2f MB", float64(p.current)/1024/1024)
+		if p.conns > 0 {
+			fmt.Printf(" 连接数: %-3d", p.conns)
+		}
+		return
+	}
 	percent := float64(p.current) / float64(p.total)
 	filledWidth := int(percent * float64(p.barWidth))
 	bar := strings.Repeat("=", filledWidth) + strings.Repeat(" ", p.barWidth-filledWidth)
@@ -44,6 +68,9 @@ func (p *ProgressBarObserver) print() {
 		float64(p.current)/1024/1024,
 		float64(p.total)/1024/1024,
 	)
+	if p.conns > 0 {
+		fmt.Printf(" 连接数: %-3d", p.conns)
+	}
 	if p.current >= p.total {
 		fmt.Println()
 	}
@@ -0,0 +1,132 @@
+// internal/ratelimit/limiter.go
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter 限制数据流动的速度
+type Limiter interface {
+	// WaitN 消耗 n 个字节的额度，额度不足时阻塞到有足够的额度或 ctx 结束
+	WaitN(ctx context.Context, n int) error
+}
+
+// Bucket 是一个令牌桶限速器，可以被多个连接共享
+// 令牌允许透支：一次读取超过桶容量时会先扣成负数，由后续的等待偿还，因此任意大小的读取都能通过
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的字节数
+	burst  float64 // 桶的容量
+	tokens float64
+	last   time.Time
+}
+
+// minBurst 是桶容量的下限，保证一次常规大小的读取 (32KB) 不会总是需要等待
+const minBurst = 64 << 10
+
+// NewBucket 创建一个每秒 bytesPerSecond 字节的令牌桶，容量为一秒的流量
+func NewBucket(bytesPerSecond int64) *Bucket {
+	burst := float64(max(bytesPerSecond, minBurst))
+	return &Bucket{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// WaitN 实现 Limiter 接口
+func (b *Bucket) WaitN(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// 归还没有用上的额度
+		b.mu.Lock()
+		b.tokens = min(b.burst, b.tokens+float64(n))
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// multi 依次等待多个限速器，速度取决于其中最慢的一个
+type multi []Limiter
+
+func (m multi) WaitN(ctx context.Context, n int) error {
+	for _, l := range m {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Join 把多个限速器组合成一个，nil 会被忽略；没有任何限速器时返回 nil
+func Join(limiters ...Limiter) Limiter {
+	var m multi
+	for _, l := range limiters {
+		if l != nil {
+			m = append(m, l)
+		}
+	}
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	}
+	return m
+}
+
+// ParseRate 解析 "20M"、"512K"、"1.5MB/s" 这样的速度，返回每秒的字节数
+// 单位按 1024 进制计算 (与 curl 的 --limit-rate 一致)，没有单位时表示字节，"0" 或空字符串表示不限速
+func ParseRate(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	if v == "" {
+		return 0, nil
+	}
+	v = strings.TrimSuffix(v, "/S")
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+
+	multiplier := 1.0
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			v = v[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("无效的速度限制: %q", s)
+	}
+	return int64(f * multiplier), nil
+}
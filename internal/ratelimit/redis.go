@@ -0,0 +1,158 @@
+// internal/ratelimit/redis.go
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// ActiveTasksKey 是记录正在消耗集群带宽的任务的有序集合，分数为最近一次申请额度的时间 (毫秒)
+	ActiveTasksKey = "bandwidth:active"
+	// taskBucketPrefix 是每个任务的令牌桶在 Redis 中的键名前缀
+	taskBucketPrefix = "bandwidth:bucket:"
+
+	// activeTTL 内没有申请过额度的任务不再参与分配
+	activeTTL = 10 * time.Second
+	// leaseDuration 决定了一次向 Redis 申请多少额度：大约是当前份额下这段时间的流量
+	// 额度在本地消耗完之后才会再次访问 Redis，避免每次读取都产生一次网络往返
+	leaseDuration = 100 * time.Millisecond
+	// fallbackRetry 是 Redis 不可用时切换到本地限速后，再次尝试 Redis 的间隔
+	fallbackRetry = 5 * time.Second
+)
+
+// acquireScript 从任务的令牌桶中申请额度
+// 集群的总速度由所有活跃任务平分：每个任务的令牌桶按 总速度/活跃任务数 补充，
+// 因此无论任务分布在哪些 Worker 上，总速度都不会超过上限。
+// 返回 {获得的额度, 额度不足时需要等待的毫秒数, 当前每个任务的份额}
+var acquireScript = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local total = tonumber(ARGV[1])
+local requested = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+
+redis.call('ZADD', KEYS[1], now, ARGV[4])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - ttl)
+local rate = total / redis.call('ZCARD', KEYS[1])
+local burst = math.max(rate, 65536)
+requested = math.min(requested, burst)
+
+local b = redis.call('HMGET', KEYS[2], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local granted, wait = 0, 0
+if tokens >= requested then
+	tokens = tokens - requested
+	granted = requested
+else
+	wait = math.ceil((requested - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[2], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[2], ttl)
+return {granted, wait, math.floor(rate)}
+`)
+
+// RedisBucket 是单个任务从集群共享的带宽预算中取用额度的限速器
+// Redis 不可用时退化为 fallback 限速，之后每隔一段时间重新尝试 Redis
+type RedisBucket struct {
+	rdb      *redis.Client
+	taskID   string
+	total    int64
+	fallback Limiter
+
+	mu        sync.Mutex
+	local     int64     // 已经从 Redis 领到、尚未消耗的额度
+	share     int64     // 最近一次从 Redis 得知的本任务份额 (字节/秒)
+	downUntil time.Time // 在此之前直接使用 fallback
+}
+
+// NewRedisBucket 创建一个任务的集群限速器，total 是整个集群的总速度 (字节/秒)
+// fallback 在 Redis 不可用时使用，为 nil 时 Redis 不可用期间不限速
+func NewRedisBucket(rdb *redis.Client, taskID string, total int64, fallback Limiter) *RedisBucket {
+	return &RedisBucket{
+		rdb:      rdb,
+		taskID:   taskID,
+		total:    total,
+		fallback: fallback,
+		share:    total,
+	}
+}
+
+// WaitN 实现 Limiter 接口
+func (b *RedisBucket) WaitN(ctx context.Context, n int) error {
+	need := int64(n)
+	for {
+		b.mu.Lock()
+		if b.local >= need {
+			b.local -= need
+			b.mu.Unlock()
+			return nil
+		}
+		need -= b.local
+		b.local = 0
+		if time.Now().Before(b.downUntil) {
+			b.mu.Unlock()
+			return b.waitFallback(ctx, need)
+		}
+		// 按当前份额一次多申请一些，减少访问 Redis 的次数
+		request := max(need, b.share*int64(leaseDuration)/int64(time.Second))
+		b.mu.Unlock()
+
+		res, err := acquireScript.Run(ctx, b.rdb,
+			[]string{ActiveTasksKey, taskBucketPrefix + b.taskID},
+			b.total, request, activeTTL.Milliseconds(), b.taskID,
+		).Int64Slice()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("⚠️ 无法从 Redis 获取带宽额度，%v 内改用本地限速: %v", fallbackRetry, err)
+			b.mu.Lock()
+			b.downUntil = time.Now().Add(fallbackRetry)
+			b.mu.Unlock()
+			return b.waitFallback(ctx, need)
+		}
+
+		granted, wait, share := res[0], time.Duration(res[1])*time.Millisecond, res[2]
+		b.mu.Lock()
+		b.local += granted
+		b.share = share
+		b.mu.Unlock()
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// waitFallback 使用本地限速器等待
+func (b *RedisBucket) waitFallback(ctx context.Context, n int64) error {
+	if b.fallback == nil {
+		return nil
+	}
+	return b.fallback.WaitN(ctx, int(n))
+}
+
+// Close 让任务退出带宽分配，其余任务的份额随之增加
+func (b *RedisBucket) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pipe := b.rdb.TxPipeline()
+	pipe.ZRem(ctx, ActiveTasksKey, b.taskID)
+	pipe.Del(ctx, taskBucketPrefix+b.taskID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,82 @@
+// internal/redact/redact.go
+package redact
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Placeholder 替换被隐藏的内容，与 url.URL.Redacted 使用的占位符相同
+const Placeholder = "xxxxx"
+
+// sensitiveNames 是查询参数名或请求头名中出现时就认为其值是凭据的片段 (不区分大小写)，
+// 例如 X-Amz-Signature、X-Amz-Credential、access_token、OSSAccessKeyId、Authorization、X-Api-Key
+var sensitiveNames = []string{"sig", "token", "secret", "passw", "key", "auth", "credential", "session", "cookie"}
+
+// URL 隐藏 URL 中的密码和疑似凭据的查询参数值，保留参数名以便排查问题
+// 无法解析的字符串原样返回
+func URL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		changed := false
+		for k := range q {
+			if SensitiveName(k) {
+				q[k] = []string{Placeholder}
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = q.Encode()
+		}
+	}
+	return u.Redacted()
+}
+
+// SensitiveName 判断名为 name 的查询参数或请求头是否疑似携带凭据
+func SensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text 隐藏一段文本 (例如错误信息) 中出现的 URL 凭据，以及 secrets 中的每一个字符串
+func Text(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, Placeholder)
+		}
+	}
+	var b strings.Builder
+	for {
+		i := urlStart(s)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		// URL 到空白或引号为止，net/url 的错误信息会把 URL 放在引号中
+		end := strings.IndexAny(s[i:], " \t\r\n\"'<>")
+		if end < 0 {
+			end = len(s) - i
+		}
+		b.WriteString(s[:i])
+		b.WriteString(URL(s[i : i+end]))
+		s = s[i+end:]
+	}
+}
+
+// urlStart 返回 s 中第一个 http:// 或 https:// 的位置，没有时返回 -1
+func urlStart(s string) int {
+	i := strings.Index(s, "http://")
+	if j := strings.Index(s, "https://"); j >= 0 && (i < 0 || j < i) {
+		i = j
+	}
+	return i
+}
@@ -4,23 +4,38 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/Slade66/parallel-fetcher/internal/redact"
 	"github.com/Slade66/parallel-fetcher/pkg/task"
 	"github.com/redis/go-redis/v9"
+	"strconv"
 	"time"
 )
 
+// CancelChannel 是用于广播任务取消请求的 Redis 频道
+const CancelChannel = "task_cancel"
+
 // StatusInfo 定义了任务状态的详细信息，用于JSON序列化
 type StatusInfo struct {
 	ID         string `json:"id"`
 	URL        string `json:"url"`
 	OutputPath string `json:"output_path"`
-	Status     string `json:"status"`
-	SubmitTime string `json:"submit_time"`
-	FinishTime string `json:"finish_time,omitempty"`
-	Error      string `json:"error,omitempty"`
+	Checksum   string `json:"checksum,omitempty"`
+	// ChecksumSource 记录了期望摘要的来源: "task" 表示由提交者提供，其余为响应头名称或摘要文件的 URL
+	ChecksumSource string `json:"checksum_source,omitempty"`
+	Status         string `json:"status"`
+	// Concurrency 是下载当前使用的连接数
+	Concurrency int `json:"concurrency,omitempty"`
+	// Size 是文件大小 (字节)，大小未知的文件在下载完成后才会填写
+	Size int64 `json:"size,omitempty"`
+	// Redirects 是 URL 的重定向链，从提交的 URL 开始到实际下载的地址结束
+	Redirects  []string `json:"redirects,omitempty"`
+	SubmitTime string   `json:"submit_time"`
+	FinishTime string   `json:"finish_time,omitempty"`
+	Error      string   `json:"error,omitempty"`
 }
 
 // Manager 结构体封装了与Redis的交互
+// 写入状态的 URL 和错误信息都会先隐藏其中的凭据，任务状态可以通过 /api/tasks 公开查看
 type Manager struct {
 	rdb *redis.Client
 }
@@ -40,11 +55,15 @@ func (m *Manager) InitTaskStatus(ctx context.Context, t *task.DownloadTask) erro
 	key := m.taskKey(t.ID.String())
 	status := StatusInfo{
 		ID:         t.ID.String(),
-		URL:        t.URL,
+		URL:        redact.URL(t.URL),
 		OutputPath: t.OutputPath, // 将 OutputPath 保存到状态中
+		Checksum:   t.Checksum,
 		Status:     "queued",
 		SubmitTime: time.Now().UTC().Format(time.RFC3339),
 	}
+	if t.Checksum != "" {
+		status.ChecksumSource = "task"
+	}
 
 	// 将 StatusInfo 结构体转换为 map[string]interface{} 以便存入 Hash
 	statusMap, err := structToMap(status)
@@ -61,8 +80,8 @@ func (m *Manager) UpdateTaskStatus(ctx context.Context, taskID, newStatus string
 	updateMap := map[string]interface{}{
 		"status": newStatus,
 	}
-	// 如果任务完成或失败，则记录完成时间
-	if newStatus == "completed" || newStatus == "failed" {
+	// 如果任务完成、失败或被取消，则记录完成时间
+	if newStatus == "completed" || newStatus == "failed" || newStatus == "canceled" {
 		updateMap["finish_time"] = time.Now().UTC().Format(time.RFC3339)
 	}
 	return m.rdb.HSet(ctx, key, updateMap).Err()
@@ -70,15 +89,105 @@ func (m *Manager) UpdateTaskStatus(ctx context.Context, taskID, newStatus string
 
 // UpdateTaskError 更新任务状态为 "failed" 并记录错误信息
 func (m *Manager) UpdateTaskError(ctx context.Context, taskID, errMsg string) error {
+	return m.UpdateTaskFailure(ctx, taskID, "failed", errMsg)
+}
+
+// UpdateTaskFailure 以指定的失败状态结束任务并记录错误信息，
+// 例如文件校验失败时使用 "checksum_mismatch"，以便与普通的下载失败区分
+func (m *Manager) UpdateTaskFailure(ctx context.Context, taskID, failStatus, errMsg string) error {
 	key := m.taskKey(taskID)
 	updateMap := map[string]interface{}{
-		"status":      "failed",
-		"error":       errMsg,
+		"status":      failStatus,
+		"error":       redact.Text(errMsg),
 		"finish_time": time.Now().UTC().Format(time.RFC3339),
 	}
 	return m.rdb.HSet(ctx, key, updateMap).Err()
 }
 
+// UpdateTaskChecksum 记录下载前自动发现的期望摘要及其来源
+func (m *Manager) UpdateTaskChecksum(ctx context.Context, taskID, digest, source string) error {
+	key := m.taskKey(taskID)
+	updateMap := map[string]interface{}{
+		"checksum":        digest,
+		"checksum_source": redact.URL(source),
+	}
+	return m.rdb.HSet(ctx, key, updateMap).Err()
+}
+
+// UpdateTaskConcurrency 记录下载当前使用的连接数
+func (m *Manager) UpdateTaskConcurrency(ctx context.Context, taskID string, connections int) error {
+	return m.rdb.HSet(ctx, m.taskKey(taskID), "concurrency", connections).Err()
+}
+
+// UpdateTaskSize 记录文件大小
+func (m *Manager) UpdateTaskSize(ctx context.Context, taskID string, size int64) error {
+	return m.rdb.HSet(ctx, m.taskKey(taskID), "size", size).Err()
+}
+
+// UpdateTaskRedirects 记录探测文件时经过的重定向链
+func (m *Manager) UpdateTaskRedirects(ctx context.Context, taskID string, chain []string) error {
+	redacted := make([]string, len(chain))
+	for i, u := range chain {
+		redacted[i] = redact.URL(u)
+	}
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		return err
+	}
+	return m.rdb.HSet(ctx, m.taskKey(taskID), "redirects", data).Err()
+}
+
+// RequestCancel 请求取消一个任务
+// 取消标记会写入任务状态，供尚未开始的任务在启动前检查；同时通过频道通知正在执行该任务的 Worker
+func (m *Manager) RequestCancel(ctx context.Context, taskID string) error {
+	key := m.taskKey(taskID)
+	exists, err := m.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return fmt.Errorf("任务 %s 不存在", taskID)
+	}
+	if err := m.rdb.HSet(ctx, key, "cancel_requested", "1").Err(); err != nil {
+		return err
+	}
+	return m.rdb.Publish(ctx, CancelChannel, taskID).Err()
+}
+
+// IsCancelRequested 判断任务是否已被请求取消
+func (m *Manager) IsCancelRequested(ctx context.Context, taskID string) bool {
+	v, err := m.rdb.HGet(ctx, m.taskKey(taskID), "cancel_requested").Result()
+	return err == nil && v == "1"
+}
+
+// CancelRequests 订阅取消请求，返回的通道中依次传递被请求取消的任务 ID
+// ctx 结束时订阅随之关闭
+func (m *Manager) CancelRequests(ctx context.Context) <-chan string {
+	sub := m.rdb.Subscribe(ctx, CancelChannel)
+	ids := make(chan string)
+	go func() {
+		defer close(ids)
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case ids <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ids
+}
+
 // GetAllTasks 获取所有任务的状态信息
 func (m *Manager) GetAllTasks(ctx context.Context) ([]StatusInfo, error) {
 	// 1. 扫描所有符合模式的键
@@ -102,14 +211,25 @@ func (m *Manager) GetAllTasks(ctx context.Context) ([]StatusInfo, error) {
 			continue
 		}
 
+		concurrency, _ := strconv.Atoi(data["concurrency"])
+		size, _ := strconv.ParseInt(data["size"], 10, 64)
+		var redirects []string
+		if v := data["redirects"]; v != "" {
+			json.Unmarshal([]byte(v), &redirects)
+		}
 		tasks = append(tasks, StatusInfo{
-			ID:         data["id"],
-			URL:        data["url"],
-			OutputPath: data["output_path"],
-			Status:     data["status"],
-			SubmitTime: data["submit_time"],
-			FinishTime: data["finish_time"],
-			Error:      data["error"],
+			ID:             data["id"],
+			URL:            data["url"],
+			OutputPath:     data["output_path"],
+			Checksum:       data["checksum"],
+			ChecksumSource: data["checksum_source"],
+			Status:         data["status"],
+			Concurrency:    concurrency,
+			Size:           size,
+			Redirects:      redirects,
+			SubmitTime:     data["submit_time"],
+			FinishTime:     data["finish_time"],
+			Error:          data["error"],
 		})
 	}
 	return tasks, nil
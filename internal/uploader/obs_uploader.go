@@ -2,14 +2,17 @@
 package uploader
 
 import (
+	"context"
 	"fmt"
 	"github.com/huaweicloud/huaweicloud-sdk-go-obs/obs"
 )
 
 // ObsUploader 结构体封装了 OBS 客户端和配置
 type ObsUploader struct {
-	client *obs.ObsClient
-	bucket string
+	client   *obs.ObsClient
+	bucket   string
+	ak, sk   string
+	endpoint string
 }
 
 // NewObsUploader 根据官方文档创建一个新的 OBS 上传器实例
@@ -21,22 +24,37 @@ func NewObsUploader(endpoint, ak, sk, bucket string) (*ObsUploader, error) {
 	}
 
 	return &ObsUploader{
-		client: client,
-		bucket: bucket,
+		client:   client,
+		bucket:   bucket,
+		ak:       ak,
+		sk:       sk,
+		endpoint: endpoint,
 	}, nil
 }
 
 // UploadFile 将指定路径的本地文件上传到 OBS
-func (u *ObsUploader) UploadFile(objectKey, filePath string) error {
-	// PutFileInput 是上传本地文件所需的参数结构体
+// ctx 被取消时会中断正在进行的上传
+func (u *ObsUploader) UploadFile(ctx context.Context, objectKey, filePath string) error {
+	// SDK 只支持在创建客户端时指定请求的 context，因此每次上传使用一个绑定了 ctx 的客户端
+	client, err := obs.New(u.ak, u.sk, u.endpoint, obs.WithRequestContext(ctx))
+	if err != nil {
+		return fmt.Errorf("无法创建 OBS 客户端: %w", err)
+	}
+	defer client.Close()
+
+	// PutFile 由 SDK 自己打开文件，请求失败后 SDK 内部重试时会重新打开文件从头发送，
+	// 而 PutObject 的 Body 无法倒回，重试时只能发送剩下的部分
 	input := &obs.PutFileInput{}
 	input.Bucket = u.bucket
-	input.Key = objectKey       // objectKey 是文件在 OBS 桶中的名字/路径
-	input.SourceFile = filePath // 本地文件的路径
+	input.Key = objectKey // objectKey 是文件在 OBS 桶中的名字/路径
+	input.SourceFile = filePath
 
 	// 调用 PutFile 方法执行上传
-	output, err := u.client.PutFile(input)
+	output, err := client.PutFile(input)
 	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("上传已取消: %w", ctx.Err())
+		}
 		// 尝试解析 OBS 返回的详细错误信息
 		if obsError, ok := err.(obs.ObsError); ok {
 			return fmt.Errorf("上传失败，OBS错误码: %s, 错误信息: %s", obsError.Code, obsError.Message)
@@ -2,60 +2,268 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net/url"
+	"net/http"
 	"os"
-	"path"
+	"path/filepath"
+	"strings"
 
+	"github.com/Slade66/parallel-fetcher/internal/client"
 	"github.com/Slade66/parallel-fetcher/internal/downloader"
 	"github.com/Slade66/parallel-fetcher/internal/observer"
+	"github.com/Slade66/parallel-fetcher/internal/ratelimit"
+	"github.com/Slade66/parallel-fetcher/internal/redact"
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
 	"github.com/Slade66/parallel-fetcher/pkg/fileinfo"
+	"github.com/Slade66/parallel-fetcher/pkg/metalink"
+	"github.com/Slade66/parallel-fetcher/pkg/task"
 )
 
 func main() {
 	// 1. 参数解析
-	urlStr := flag.String("url", "", "要下载的文件的 URL (必须)")
-	output := flag.String("output", "", "文件保存路径 (如果为空，则从URL中自动提取)")
-	threads := flag.Int("threads", 10, "下载时使用的线程数")
+	urlStr := flag.String("url", "", "要下载的文件的 URL (与 -metalink 二选一)")
+	metalinkSource := flag.String("metalink", "", "Metalink 文档 (.meta4) 的路径或 URL，下载其中的每一个文件 (与 -url 二选一)")
+	output := flag.String("output", "", "文件保存路径 (如果为空，则使用服务器建议的文件名或从URL中自动提取)；使用 -metalink 时为保存目录")
+	var mirrors []string
+	flag.Func("mirror", "与 -url 内容相同的镜像地址，分片会按速度分散到各个地址上，可以重复指定", func(v string) error {
+		mirrors = append(mirrors, v)
+		return nil
+	})
+	threads := flag.Int("threads", 10, "下载时使用的线程数 (开启 -adaptive 时为连接数的上限)")
+	adaptive := flag.Bool("adaptive", false, "根据实际吞吐量自动调整连接数")
+	retries := flag.Int("retries", downloader.DefaultRetryPolicy().MaxRetries, "单个分片失败后的最大重试次数")
+	minChunk := flag.Int64("min-chunk", downloader.DefaultMinChunkSize>>20, "最小分片大小 (MB)")
+	maxChunk := flag.Int64("max-chunk", downloader.DefaultMaxChunkSize>>20, "最大分片大小 (MB)")
+	stallTimeout := flag.Duration("stall-timeout", downloader.DefaultStallTimeout, "连接超过该时间没有收到数据时中止并重试 (0 表示不检测)")
+	expectedChecksum := flag.String("checksum", "", "文件的期望摘要，格式为 算法:摘要 (支持 sha256/sha512/sha1/md5/crc32c)")
+	probeSidecar := flag.Bool("probe-sidecar", false, "未指定 -checksum 且响应头中没有摘要时，探测 <文件>.sha256、SHA256SUMS 等摘要文件")
+	limitRate := flag.String("limit-rate", "", "下载速度上限，例如 500K、20M (为空表示不限速)")
+	headers := make(map[string]string)
+	flag.Func("header", "附加的请求头，格式为 \"名称: 值\"，可以重复指定", func(v string) error {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return fmt.Errorf("格式应为 \"名称: 值\"")
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		return nil
+	})
+	cookie := flag.String("cookie", "", "Cookie 请求头的值，例如 \"session=abc; theme=dark\"")
+	user := flag.String("user", "", "HTTP Basic 认证的用户名和密码，格式为 用户名:密码")
+	bearerToken := flag.String("bearer-token", "", "以 Authorization: Bearer 发送的令牌")
+	transport := client.DefaultConfig()
+	flag.StringVar(&transport.Proxy, "proxy", "", "代理地址，支持 http://、https:// 和 socks5:// (为空时使用 HTTP_PROXY 等环境变量，direct 表示不使用代理)")
+	flag.StringVar(&transport.CAFile, "cacert", "", "额外信任的 CA 证书 (PEM) 文件")
+	flag.StringVar(&transport.CertFile, "cert", "", "mTLS 客户端证书 (PEM) 文件")
+	flag.StringVar(&transport.KeyFile, "key", "", "mTLS 客户端私钥 (PEM) 文件")
+	flag.Func("insecure-host", "不校验该主机的服务器证书，可以重复指定", func(v string) error {
+		transport.InsecureSkipVerifyHosts = append(transport.InsecureSkipVerifyHosts, v)
+		return nil
+	})
+	flag.BoolVar(&transport.DisableHTTP2, "no-http2", false, "只使用 HTTP/1.1")
+	flag.IntVar(&transport.MaxConnsPerHost, "max-conns-per-host", 0, "同一主机的最大连接数 (0 表示不限制)")
+	flag.StringVar(&transport.HTTP3, "http3", "", "使用 HTTP/3: auto (根据 Alt-Svc 自动发现) 或 always (总是先尝试)，不可用时退回 HTTP/1.1 或 HTTP/2")
+	flag.Func("resolve", "把主机名固定解析到指定的 IP，格式为 主机名=IP[,IP...]，可以重复指定", func(v string) error {
+		host, addrs, ok := strings.Cut(v, "=")
+		if !ok || host == "" || addrs == "" {
+			return fmt.Errorf("格式应为 主机名=IP[,IP...]")
+		}
+		if transport.Hosts == nil {
+			transport.Hosts = make(map[string][]string)
+		}
+		transport.Hosts[host] = append(transport.Hosts[host], strings.Split(addrs, ",")...)
+		return nil
+	})
+	flag.BoolVar(&transport.SpreadAddrs, "spread-addrs", false, "主机解析出多个 IP 时，让各个连接轮流使用不同的 IP (HTTP/2 下所有分片共用一个连接，需配合 -no-http2)")
+	flag.Func("local-addr", "连接使用的本地源地址 (IP 或网卡名)，可以重复指定，各个连接轮流绑定", func(v string) error {
+		transport.LocalAddrs = append(transport.LocalAddrs, v)
+		return nil
+	})
+	flag.IntVar(&transport.MaxIdleConnsPerHost, "max-idle-conns-per-host", transport.MaxIdleConnsPerHost, "每个主机保留的最大空闲连接数")
+	writeMode := flag.String("write-mode", string(downloader.WriteDirect), "落盘方式: direct (预分配输出文件并直接写入) 或 parts (分片文件下载完成后合并)")
 	flag.Parse()
 
 	// 2. 参数校验和文件名处理
-	if *urlStr == "" {
-		fmt.Println("错误: -url 参数是必须的")
+	if (*urlStr == "") == (*metalinkSource == "") {
+		fmt.Println("错误: 必须指定 -url 或 -metalink 中的一个")
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *metalinkSource != "" && (len(mirrors) > 0 || *expectedChecksum != "") {
+		log.Fatalf("❌ 使用 -metalink 时镜像和摘要来自 Metalink 文档，不能再指定 -mirror 或 -checksum")
+	}
+
+	if mode := downloader.WriteMode(*writeMode); mode != downloader.WriteDirect && mode != downloader.WriteParts {
+		log.Fatalf("❌ 无效的 -write-mode: %s", *writeMode)
+	}
+
+	rate, err := ratelimit.ParseRate(*limitRate)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	// 请求头和凭据的校验规则与 API 提交的任务相同
+	username, password, _ := strings.Cut(*user, ":")
+	creds := &task.DownloadTask{Headers: headers, Cookies: *cookie, BearerToken: *bearerToken, Username: username, Password: password}
+	header, err := creds.RequestHeader()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	httpClient, err := client.New(transport)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
 
-	if *output == "" {
-		parsedURL, err := url.Parse(*urlStr)
+	var jobs []downloadJob
+	if *metalinkSource != "" {
+		if jobs, err = metalinkJobs(httpClient, *metalinkSource, *output); err != nil {
+			log.Fatalf("❌ %s", redact.Text(err.Error()))
+		}
+	} else {
+		j := downloadJob{url: *urlStr, output: *output, mirrors: mirrors}
+		if *expectedChecksum != "" {
+			if j.digest, err = checksum.Parse(*expectedChecksum); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		jobs = append(jobs, j)
+	}
+
+	// 每个要下载的文件依次下载，任意一个失败时退出
+	for _, j := range jobs {
+		// 3. 获取文件信息 (使用新包)
+		fmt.Println("🔎 正在获取文件信息...")
+		info, err := fileinfo.Get(context.Background(), j.url, fileinfo.WithClient(httpClient), fileinfo.WithHeader(header))
 		if err != nil {
-			log.Fatalf("❌ 无法解析提供的URL: %v", err)
+			log.Fatalf("❌ %s", redact.Text(err.Error(), creds.Secrets()...))
+		}
+		if j.size > 0 && info.Size >= 0 && info.Size != j.size {
+			log.Fatalf("❌ 远程文件大小 %d 与 Metalink 中的 %d 不一致", info.Size, j.size)
 		}
-		filename := path.Base(parsedURL.Path)
-		if filename == "" || filename == "." || filename == "/" {
-			log.Fatalf("❌ 无法从URL [%s] 中自动提取有效的文件名，请使用 -output 参数手动指定。", *urlStr)
+
+		// 未指定保存路径时，使用服务器在 Content-Disposition 中建议的文件名，或者重定向后 URL 路径的最后一段
+		if j.output == "" {
+			if info.Filename == "" {
+				log.Fatalf("❌ 无法从URL [%s] 中自动提取有效的文件名，请使用 -output 参数手动指定。", j.url)
+			}
+			j.output = info.Filename
+			fmt.Printf("📄 文件将保存为: %s\n", j.output)
 		}
-		*output = filename
+
+		if j.digest.IsZero() {
+			if info.Checksum.IsZero() && *probeSidecar {
+				if info.Checksum, info.ChecksumSource, err = fileinfo.FindSidecarChecksum(context.Background(), j.url, fileinfo.WithClient(httpClient), fileinfo.WithHeader(header)); err != nil {
+					fmt.Printf("ℹ️ 未找到摘要文件: %v\n", err)
+				}
+			}
+			if !info.Checksum.IsZero() {
+				fmt.Printf("🔐 将使用 %s 提供的摘要进行校验: %s\n", redact.URL(info.ChecksumSource), info.Checksum)
+			}
+		}
+
+		if len(info.Redirects) > 0 {
+			fmt.Printf("↪️ 经过 %d 次重定向，实际下载地址: %s\n", len(info.Redirects)-1, redact.URL(info.FinalURL))
+		}
+
+		// 4. 创建下载器和观察者
+		// 命令行模式下不上传到 OBS，文件直接保存到 output 路径
+		d := downloader.New(j.url, j.output, *threads, info, nil)
+		retryPolicy := downloader.DefaultRetryPolicy()
+		retryPolicy.MaxRetries = *retries
+		d.SetRetryPolicy(retryPolicy)
+		d.SetAdaptive(*adaptive)
+		d.SetHeader(header)
+		d.SetClient(httpClient)
+		d.SetChunkSize(*minChunk<<20, *maxChunk<<20)
+		d.SetWriteMode(downloader.WriteMode(*writeMode))
+		d.SetStallTimeout(*stallTimeout)
+		d.SetRateLimit(rate)
+		if !j.digest.IsZero() {
+			d.SetExpectedChecksum(j.digest)
+		}
+		d.SetProbeSidecar(*probeSidecar)
+		if len(j.pieces) > 0 {
+			if err := d.SetPieceChecksums(j.pieceLength, j.pieces); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		for _, m := range j.mirrors {
+			mirrorInfo, err := fileinfo.Get(context.Background(), m, fileinfo.WithClient(httpClient), fileinfo.WithHeader(d.HeaderFor(m)))
+			if err == nil {
+				err = d.AddMirror(m, mirrorInfo)
+			}
+			if err != nil {
+				fmt.Printf("⚠️ 不使用镜像 %s: %s\n", redact.URL(m), redact.Text(err.Error(), creds.Secrets()...))
+				continue
+			}
+			fmt.Printf("🪞 使用镜像: %s\n", redact.URL(m))
+		}
+		progressBar := observer.NewProgressBarObserver(info.Size)
+		d.AddObserver(progressBar)
+
+		// 5. 启动下载
+		// 命令行模式不在收到信号时取消 ctx：直接退出的进程会保留下载进度，重新运行即可续传
+		fmt.Println("🚀 开始下载...")
+		if err := d.Run(context.Background()); err != nil {
+			log.Fatalf("\n❌ 下载过程中发生严重错误: %s", redact.Text(err.Error(), creds.Secrets()...))
+		}
+		fmt.Println("✅ 文件下载并合并完成！")
 	}
+}
 
-	// 3. 获取文件信息 (使用新包)
-	fmt.Println("🔎 正在获取文件信息...")
-	info, err := fileinfo.Get(*urlStr)
+// downloadJob 是一个要下载的文件，使用 -metalink 时文档中的每个文件各对应一个
+type downloadJob struct {
+	url         string
+	mirrors     []string
+	output      string
+	size        int64 // 期望的文件大小，为 0 时不检查
+	digest      checksum.Digest
+	pieceLength int64
+	pieces      []checksum.Digest
+}
+
+// loadMetalink 读取 source 指向的 Metalink 文档，source 可以是 http(s) URL 或本地文件路径
+func loadMetalink(c *http.Client, source string) (*metalink.Metalink, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return metalink.LoadURL(context.Background(), c, source)
+	}
+	f, err := os.Open(source)
 	if err != nil {
-		log.Fatalf("❌ %v", err)
+		return nil, fmt.Errorf("无法读取 Metalink 文件: %w", err)
 	}
+	defer f.Close()
+	return metalink.Parse(f)
+}
 
-	// 4. 创建下载器和观察者
-	d := downloader.New(*urlStr, *output, *threads, info.Size, info.AcceptsRanges)
-	progressBar := observer.NewProgressBarObserver(info.Size)
-	d.AddObserver(progressBar)
-
-	// 5. 启动下载
-	fmt.Println("🚀 开始下载...")
-	if err := d.Run(); err != nil {
-		log.Fatalf("\n❌ 下载过程中发生严重错误: %v", err)
+// metalinkJobs 读取 Metalink 文档，为其中的每个文件创建一个下载，文件保存在 dir 下
+// 优先级最高的地址作为 URL，其余地址作为镜像
+func metalinkJobs(c *http.Client, source, dir string) ([]downloadJob, error) {
+	doc, err := loadMetalink(c, source)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]downloadJob, 0, len(doc.Files))
+	for _, f := range doc.Files {
+		urls := f.HTTPURLs()
+		if len(urls) == 0 {
+			return nil, fmt.Errorf("文件 %s 没有 http(s) 下载地址", f.Name)
+		}
+		j := downloadJob{url: urls[0], mirrors: urls[1:], output: filepath.Join(dir, filepath.FromSlash(f.Name)), size: f.Size}
+		// 文件名可以包含子目录
+		if err := os.MkdirAll(filepath.Dir(j.output), 0755); err != nil {
+			return nil, fmt.Errorf("无法创建目录: %w", err)
+		}
+		if j.digest, err = f.Checksum(); err != nil {
+			return nil, err
+		}
+		if j.pieceLength, j.pieces, err = f.PieceChecksums(); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
 	}
-	fmt.Println("✅ 文件下载并合并完成！")
+	fmt.Printf("📑 Metalink 文档中有 %d 个文件\n", len(jobs))
+	return jobs, nil
 }
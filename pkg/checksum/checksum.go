@@ -0,0 +1,127 @@
+// pkg/checksum/checksum.go
+package checksum
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+// 支持的摘要算法
+const (
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	SHA1   = "sha1"
+	MD5    = "md5"
+	CRC32C = "crc32c"
+)
+
+// sizes 记录了每种算法的摘要长度 (字节)
+var sizes = map[string]int{
+	SHA256: sha256.Size,
+	SHA512: sha512.Size,
+	SHA1:   sha1.Size,
+	MD5:    md5.Size,
+	CRC32C: crc32.Size,
+}
+
+// Digest 表示一个文件的期望摘要
+type Digest struct {
+	Algorithm string
+	Value     []byte
+}
+
+// Parse 解析 "算法:摘要" 格式的字符串，例如 "sha256:9f86d08..."
+// 算法名不区分大小写，可以写作 "SHA-256"；摘要可以是十六进制或 base64 编码
+func Parse(s string) (Digest, error) {
+	algo, value, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return Digest{}, fmt.Errorf("无效的摘要 %q: 应为 算法:摘要 的格式", s)
+	}
+	return New(algo, value)
+}
+
+// New 根据算法名和编码后的摘要值创建 Digest
+func New(algorithm, value string) (Digest, error) {
+	algo := NormalizeAlgorithm(algorithm)
+	size, ok := sizes[algo]
+	if !ok {
+		return Digest{}, fmt.Errorf("不支持的摘要算法: %s", algorithm)
+	}
+	value = strings.TrimSpace(value)
+	if b, err := hex.DecodeString(value); err == nil && len(b) == size {
+		return Digest{Algorithm: algo, Value: b}, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(value); err == nil && len(b) == size {
+			return Digest{Algorithm: algo, Value: b}, nil
+		}
+	}
+	return Digest{}, fmt.Errorf("无效的 %s 摘要: %s", algo, value)
+}
+
+// NormalizeAlgorithm 把 "SHA-256"、"Sha256" 等写法统一为内部使用的算法名
+func NormalizeAlgorithm(algorithm string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(algorithm)), "-", "")
+}
+
+// IsZero 判断 Digest 是否为空 (即没有期望摘要)
+func (d Digest) IsZero() bool {
+	return d.Algorithm == ""
+}
+
+// String 返回 "算法:十六进制摘要" 格式的字符串
+func (d Digest) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Algorithm + ":" + hex.EncodeToString(d.Value)
+}
+
+// NewHash 返回用于计算该摘要的 hash.Hash
+func (d Digest) NewHash() hash.Hash {
+	switch d.Algorithm {
+	case SHA256:
+		return sha256.New()
+	case SHA512:
+		return sha512.New()
+	case SHA1:
+		return sha1.New()
+	case MD5:
+		return md5.New()
+	case CRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	}
+	return nil
+}
+
+// Verify 比较计算出的摘要与期望值，不一致时返回 *MismatchError
+func (d Digest) Verify(sum []byte) error {
+	if bytes.Equal(sum, d.Value) {
+		return nil
+	}
+	return &MismatchError{
+		Algorithm: d.Algorithm,
+		Expected:  hex.EncodeToString(d.Value),
+		Actual:    hex.EncodeToString(sum),
+	}
+}
+
+// MismatchError 表示下载得到的文件与期望的摘要不一致，可以通过 errors.As 获取
+type MismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("文件校验失败 (%s): 期望 %s，实际 %s", e.Algorithm, e.Expected, e.Actual)
+}
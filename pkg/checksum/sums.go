@@ -0,0 +1,58 @@
+// pkg/checksum/sums.go
+package checksum
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrNotListed 表示摘要文件中没有目标文件的记录
+var ErrNotListed = errors.New("摘要文件中没有该文件的记录")
+
+// ParseSums 从 sha256sum 等工具生成的摘要文件中找出 name 对应的摘要
+// 支持 GNU 格式 ("<摘要>  <文件名>"，二进制模式下文件名前带 '*") 和 BSD 格式 ("SHA256 (<文件名>) = <摘要>")。
+// 只有一行且只包含摘要的文件 (常见于 <文件>.sha256) 视为 name 的摘要。
+func ParseSums(r io.Reader, algorithm, name string) (Digest, error) {
+	algo := NormalizeAlgorithm(algorithm)
+	var lone string // 只包含摘要、没有文件名的行
+	lines := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines++
+
+		// BSD 格式: SHA256 (file.bin) = 9f86d08...
+		if open := strings.Index(line, " ("); open > 0 && strings.Contains(line, ") = ") {
+			closeAt := strings.LastIndex(line, ") = ")
+			if NormalizeAlgorithm(line[:open]) == algo && path.Base(line[open+2:closeAt]) == name {
+				return New(algo, line[closeAt+4:])
+			}
+			continue
+		}
+
+		value, file, ok := strings.Cut(line, " ")
+		if !ok {
+			lone = line
+			continue
+		}
+		file = strings.TrimPrefix(strings.TrimLeft(file, " "), "*")
+		if path.Base(file) == name {
+			return New(algo, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Digest{}, fmt.Errorf("读取摘要文件失败: %w", err)
+	}
+	if lines == 1 && lone != "" {
+		return New(algo, lone)
+	}
+	return Digest{}, ErrNotListed
+}
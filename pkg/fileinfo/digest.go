@@ -0,0 +1,79 @@
+// pkg/fileinfo/digest.go
+package fileinfo
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+)
+
+// preferredAlgorithms 是从响应头中选择摘要时的优先顺序，越靠前越可靠
+var preferredAlgorithms = []string{checksum.SHA512, checksum.SHA256, checksum.SHA1, checksum.MD5, checksum.CRC32C}
+
+// digestFromHeader 从响应头中找出文件的摘要，返回摘要及其来源 (响应头名称)
+// 依次查看 RFC 9530 的 Repr-Digest 和 Content-Digest、RFC 3230 的 Digest、
+// S3 的 x-amz-checksum-* 以及 Content-MD5，同一个响应头中有多个摘要时选择最可靠的算法
+// partial 表示这是 206 响应，此时只有描述整个文件的 Repr-Digest 和 Digest 可用
+func digestFromHeader(h http.Header, partial bool) (checksum.Digest, string) {
+	// Content-Digest 描述的是传输的内容，只有没有内容编码时才等于文件本身的摘要
+	encoded := partial || h.Get("Content-Encoding") != "" && !strings.EqualFold(h.Get("Content-Encoding"), "identity")
+
+	for _, name := range []string{"Repr-Digest", "Content-Digest", "Digest"} {
+		if name == "Content-Digest" && encoded {
+			continue
+		}
+		if d, ok := pickDigest(parseDigestField(h.Values(name))); ok {
+			return d, name
+		}
+	}
+
+	// 分段上传的对象使用的是各段摘要的组合 (值带有 "-<段数>" 后缀)，与整个文件的摘要不同
+	if !partial && !strings.EqualFold(h.Get("X-Amz-Checksum-Type"), "COMPOSITE") {
+		amz := make(map[string]string)
+		for _, algo := range []string{checksum.SHA256, checksum.SHA1, checksum.CRC32C} {
+			if v := h.Get("X-Amz-Checksum-" + algo); v != "" && !strings.Contains(v, "-") {
+				amz[algo] = v
+			}
+		}
+		if d, ok := pickDigest(amz); ok {
+			return d, "x-amz-checksum-" + d.Algorithm
+		}
+	}
+
+	if v := h.Get("Content-MD5"); v != "" && !encoded {
+		if d, err := checksum.New(checksum.MD5, v); err == nil {
+			return d, "Content-MD5"
+		}
+	}
+	return checksum.Digest{}, ""
+}
+
+// parseDigestField 解析摘要响应头，返回算法到摘要值的映射
+// 同时兼容 RFC 9530 的格式 (sha-256=:<base64>:) 和 RFC 3230 的格式 (SHA-256=<base64>)
+func parseDigestField(values []string) map[string]string {
+	digests := make(map[string]string)
+	for _, v := range values {
+		for _, member := range strings.Split(v, ",") {
+			algo, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok {
+				continue
+			}
+			value, _, _ = strings.Cut(value, ";") // 忽略参数
+			digests[checksum.NormalizeAlgorithm(algo)] = strings.Trim(strings.TrimSpace(value), ":")
+		}
+	}
+	return digests
+}
+
+// pickDigest 按 preferredAlgorithms 的顺序选出第一个有效的摘要
+func pickDigest(digests map[string]string) (checksum.Digest, bool) {
+	for _, algo := range preferredAlgorithms {
+		if v, ok := digests[algo]; ok {
+			if d, err := checksum.New(algo, v); err == nil {
+				return d, true
+			}
+		}
+	}
+	return checksum.Digest{}, false
+}
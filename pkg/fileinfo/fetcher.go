@@ -2,37 +2,202 @@
 package fileinfo
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
 )
 
+// UnknownSize 表示服务器没有告知文件大小，例如动态生成的导出文件或使用分块传输编码的响应
+const UnknownSize int64 = -1
+
 // Info 包含了文件的元信息
 type Info struct {
-	Size          int64
+	Size          int64 // 文件大小未知时为 UnknownSize
 	AcceptsRanges bool
+	ETag          string // 用于判断远程文件是否发生了变化
+	LastModified  string
+	ContentType   string
+	// FinalURL 是跟随重定向之后实际提供文件的 URL
+	FinalURL string
+	// Redirects 是探测时经过的重定向链，从请求的 URL 开始到 FinalURL 结束，没有重定向时为 nil
+	Redirects []string
+	// Filename 是服务器建议的文件名：优先取自 Content-Disposition，否则取 FinalURL 路径的最后一段，
+	// 都无法得到时为空字符串
+	Filename string
+	// Checksum 是服务器在响应头中提供的文件摘要，没有时为零值
+	Checksum checksum.Digest
+	// ChecksumSource 记录了摘要的来源，例如 "Repr-Digest" 或摘要文件的 URL
+	ChecksumSource string
+}
+
+// Get 探测远程文件的信息，服务器在响应头中提供了摘要时一并返回
+// 先发送只请求第一个字节的 GET 请求 (Range: bytes=0-0)：下载使用的是 GET，而很多服务器拒绝 HEAD，
+// 或者对 HEAD 和 GET 返回不同的响应头，因此文件大小和是否支持 Range 以 GET 的响应为准 (返回 206 才认为支持)
+// 只有 GET 探测失败时才发送 HEAD 请求作为后备，正常情况下每次探测只需要一个请求
+// 206 响应中只有描述整个文件的 Repr-Digest 和 Digest 可以作为文件的摘要
+func Get(ctx context.Context, url string, opts ...Option) (*Info, error) {
+	o := newOptions(opts)
+	info, rangeErr := o.probeRange(ctx, url)
+	if rangeErr != nil {
+		var headErr error
+		if info, headErr = o.probeHead(ctx, url); headErr != nil {
+			return nil, fmt.Errorf("无法获取文件信息: %w", errors.Join(rangeErr, headErr))
+		}
+	}
+	if info.Filename == "" {
+		info.Filename = FilenameFromURL(info.FinalURL)
+	}
+	return info, nil
 }
 
-// Get 发送 HEAD 请求以获取远程文件的信息
-func Get(url string) (*Info, error) {
-	resp, err := http.Head(url)
+// probeHead 发送 HEAD 请求获取文件信息
+func (o *options) probeHead(ctx context.Context, url string) (*Info, error) {
+	req, err := o.newRequest(ctx, http.MethodHead, url)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建请求: %w", err)
+	}
+	// 请求 RFC 9530 的摘要响应头
+	// 不发送 x-amz-checksum-mode：预签名 URL 中未签名的 x-amz-* 请求头会导致签名校验失败
+	req.Header.Set("Want-Repr-Digest", "sha-512=10, sha-256=9")
+	resp, err := o.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("无法获取文件信息: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HEAD 请求失败: %s", resp.Status)
+	}
+	info := newInfo(resp)
+
+	// 没有 Content-Length (例如分块传输编码) 时文件大小未知，由下载器使用单个连接顺序下载
+	if contentLengthStr := resp.Header.Get("Content-Length"); contentLengthStr != "" {
+		info.Size, err = strconv.ParseInt(contentLengthStr, 10, 64)
+		if err != nil || info.Size < 0 {
+			return nil, fmt.Errorf("无效的文件大小: %q", contentLengthStr)
+		}
+	}
+	info.AcceptsRanges = resp.Header.Get("Accept-Ranges") == "bytes"
+	info.Checksum, info.ChecksumSource = digestFromHeader(resp.Header, false)
+	return info, nil
+}
+
+// newInfo 从响应中取出与请求方式无关的文件信息，文件大小先记为未知，文件名只取自 Content-Disposition
+func newInfo(resp *http.Response) *Info {
+	info := &Info{
+		Size:         UnknownSize,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+		FinalURL:     resp.Request.URL.String(),
+		Redirects:    redirectChain(resp),
+	}
+	info.Filename = filenameFromDisposition(resp.Header.Get("Content-Disposition"))
+	return info
+}
 
-	contentLengthStr := resp.Header.Get("Content-Length")
-	if contentLengthStr == "" {
-		return nil, fmt.Errorf("无法获取文件大小 (Content-Length is missing)")
+// redirectChain 沿着 Request.Response 向前回溯，按顺序返回经过的每一个 URL
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for req := resp.Request; req != nil; {
+		chain = append(chain, req.URL.String())
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
 	}
+	if len(chain) < 2 {
+		return nil
+	}
+	slices.Reverse(chain)
+	return chain
+}
 
-	size, err := strconv.ParseInt(contentLengthStr, 10, 64)
+// probeRange 发送 Range: bytes=0-0 的 GET 请求获取文件信息，不读取响应体
+// 206 表示支持 Range，文件大小取自 Content-Range；200 表示不支持，文件大小取自 Content-Length
+func (o *options) probeRange(ctx context.Context, url string) (*Info, error) {
+	req, err := o.newRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建请求: %w", err)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	req.Header.Set("Want-Repr-Digest", "sha-512=10, sha-256=9")
+	resp, err := o.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("无效的文件大小: %w", err)
+		return nil, err
 	}
+	// 不支持 Range 的服务器会返回整个文件，这里只需要响应头
+	resp.Body.Close()
 
-	return &Info{
-		Size:          size,
-		AcceptsRanges: resp.Header.Get("Accept-Ranges") == "bytes",
-	}, nil
+	info := newInfo(resp)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		info.AcceptsRanges = true
+		_, _, size, err := ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		info.Size = size
+		info.Checksum, info.ChecksumSource = digestFromHeader(resp.Header, true)
+	case http.StatusOK:
+		if resp.ContentLength >= 0 {
+			info.Size = resp.ContentLength
+		}
+		info.Checksum, info.ChecksumSource = digestFromHeader(resp.Header, false)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 空文件没有第一个字节可以请求，Content-Range 为 "bytes */0"
+		_, _, size, err := ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		info.Size = size
+	default:
+		return nil, fmt.Errorf("GET 请求失败: %s", resp.Status)
+	}
+	return info, nil
+}
+
+// ParseContentRange 解析 "bytes 0-499/1234" 形式的 Content-Range，总大小为 "*" 时 total 为 UnknownSize
+// 416 响应中的 "bytes */1234" 没有字节范围，此时 first 和 last 都为 -1
+func ParseContentRange(contentRange string) (first, last, total int64, err error) {
+	invalid := fmt.Errorf("无效的 Content-Range: %q", contentRange)
+	unit, rest, ok := strings.Cut(strings.TrimSpace(contentRange), " ")
+	if !ok || unit != "bytes" {
+		return 0, 0, 0, invalid
+	}
+	byteRange, size, ok := strings.Cut(rest, "/")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	total = UnknownSize
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil || total < 0 {
+			return 0, 0, 0, invalid
+		}
+	}
+	if byteRange == "*" {
+		if total == UnknownSize {
+			return 0, 0, 0, invalid
+		}
+		return -1, -1, total, nil
+	}
+	firstStr, lastStr, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	if first, err = strconv.ParseInt(firstStr, 10, 64); err != nil || first < 0 {
+		return 0, 0, 0, invalid
+	}
+	if last, err = strconv.ParseInt(lastStr, 10, 64); err != nil || last < first {
+		return 0, 0, 0, invalid
+	}
+	if total != UnknownSize && total <= last {
+		return 0, 0, 0, invalid
+	}
+	return first, last, total, nil
 }
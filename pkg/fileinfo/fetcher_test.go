@@ -0,0 +1,83 @@
+// pkg/fileinfo/fetcher_test.go
+package fileinfo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetSendsOneRequest(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "file.bin", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer srv.Close()
+
+	info, err := Get(context.Background(), srv.URL+"/file.bin", WithClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 10 || !info.AcceptsRanges {
+		t.Fatalf("Size = %d, AcceptsRanges = %v，期望 10 和 true", info.Size, info.AcceptsRanges)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("探测发送了 %d 个请求，期望 1 个", n)
+	}
+}
+
+func TestGetFallsBackToHead(t *testing.T) {
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method != http.MethodHead {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", "10")
+	}))
+	defer srv.Close()
+
+	info, err := Get(context.Background(), srv.URL+"/file.bin", WithClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 10 || !info.AcceptsRanges {
+		t.Fatalf("Size = %d, AcceptsRanges = %v，期望 10 和 true", info.Size, info.AcceptsRanges)
+	}
+	if strings.Join(methods, ",") != "GET,HEAD" {
+		t.Fatalf("请求顺序为 %v，期望先 GET 后 HEAD", methods)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	cases := []struct {
+		in                 string
+		first, last, total int64
+		ok                 bool
+	}{
+		{"bytes 0-499/1234", 0, 499, 1234, true},
+		{"bytes 500-999/*", 500, 999, UnknownSize, true},
+		{"bytes */0", -1, -1, 0, true},
+		{"bytes */*", 0, 0, 0, false},
+		{"bytes 10-5/100", 0, 0, 0, false},
+		{"bytes 0-99/99", 0, 0, 0, false},
+		{"items 0-1/2", 0, 0, 0, false},
+	}
+	for _, c := range cases {
+		first, last, total, err := ParseContentRange(c.in)
+		if (err == nil) != c.ok {
+			t.Errorf("ParseContentRange(%q) 的错误为 %v", c.in, err)
+			continue
+		}
+		if c.ok && (first != c.first || last != c.last || total != c.total) {
+			t.Errorf("ParseContentRange(%q) = %d, %d, %d，期望 %d, %d, %d", c.in, first, last, total, c.first, c.last, c.total)
+		}
+	}
+}
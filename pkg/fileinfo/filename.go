@@ -0,0 +1,40 @@
+// pkg/fileinfo/filename.go
+package fileinfo
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// filenameFromDisposition 从 Content-Disposition 中取出文件名
+// 同时存在时优先使用 RFC 5987 的 filename* (可以包含非 ASCII 字符)，mime.ParseMediaType 会完成解码
+func filenameFromDisposition(disposition string) string {
+	if disposition == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(disposition)
+	if err != nil {
+		return ""
+	}
+	return sanitizeFilename(params["filename"])
+}
+
+// FilenameFromURL 取 URL 路径的最后一段作为文件名，查询参数会被忽略，无法得到有效的文件名时返回空字符串
+func FilenameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return sanitizeFilename(path.Base(u.Path))
+}
+
+// sanitizeFilename 去掉文件名中的目录部分，防止服务器提供的文件名把文件写到其他目录
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(strings.TrimSpace(name), "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,54 @@
+// pkg/fileinfo/options.go
+package fileinfo
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Slade66/parallel-fetcher/internal/client"
+)
+
+// Option 用于定制探测请求
+type Option func(*options)
+
+type options struct {
+	client *http.Client
+	header http.Header
+}
+
+// WithClient 指定发送探测请求的 http.Client，默认使用与下载器相同的共享客户端
+func WithClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithHeader 为探测请求附加请求头，应与下载时使用的请求头 (例如认证信息) 保持一致，
+// 否则服务器可能对探测和下载给出不同的结果
+func WithHeader(h http.Header) Option {
+	return func(o *options) {
+		o.header = h
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{client: client.GetClient()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// newRequest 创建一个带有自定义请求头的请求
+func (o *options) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range o.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	return req, nil
+}
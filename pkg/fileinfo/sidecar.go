@@ -0,0 +1,88 @@
+// pkg/fileinfo/sidecar.go
+package fileinfo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+)
+
+// maxSidecarSize 是摘要文件的大小上限，避免把误配置的大文件读入内存
+const maxSidecarSize = 1 << 20
+
+// ErrNoSidecar 表示没有找到可用的摘要文件
+var ErrNoSidecar = errors.New("没有找到摘要文件")
+
+// sidecar 描述了一个可能存在的摘要文件
+type sidecar struct {
+	url       string
+	algorithm string
+}
+
+// sidecarCandidates 返回需要探测的摘要文件，依次为 <url>.sha512、<url>.sha256
+// 以及同一目录下的 SHA512SUMS、SHA256SUMS
+func sidecarCandidates(u *url.URL) []sidecar {
+	var candidates []sidecar
+	for _, algo := range []string{checksum.SHA512, checksum.SHA256} {
+		c := *u
+		c.Path += "." + algo
+		c.RawPath = ""
+		candidates = append(candidates, sidecar{url: c.String(), algorithm: algo})
+	}
+	for _, name := range []string{"SHA512SUMS", "SHA256SUMS"} {
+		c := *u
+		c.Path = path.Join(path.Dir(u.Path), name)
+		c.RawPath = ""
+		c.RawQuery = ""
+		candidates = append(candidates, sidecar{url: c.String(), algorithm: name[:6]})
+	}
+	return candidates
+}
+
+// FindSidecarChecksum 探测与文件一起发布的摘要文件，返回文件的摘要及摘要文件的 URL
+// 所有候选都不可用时返回 ErrNoSidecar
+func FindSidecarChecksum(ctx context.Context, rawURL string, opts ...Option) (checksum.Digest, string, error) {
+	o := newOptions(opts)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return checksum.Digest{}, "", fmt.Errorf("无法解析 URL: %w", err)
+	}
+	name := path.Base(u.Path)
+	if name == "" || name == "." || name == "/" {
+		return checksum.Digest{}, "", ErrNoSidecar
+	}
+
+	for _, c := range sidecarCandidates(u) {
+		d, err := o.fetchSidecar(ctx, c, name)
+		if err == nil {
+			return d, c.url, nil
+		}
+		if ctx.Err() != nil {
+			return checksum.Digest{}, "", ctx.Err()
+		}
+	}
+	return checksum.Digest{}, "", ErrNoSidecar
+}
+
+// fetchSidecar 下载一个摘要文件并从中找出 name 的摘要
+func (o *options) fetchSidecar(ctx context.Context, c sidecar, name string) (checksum.Digest, error) {
+	req, err := o.newRequest(ctx, http.MethodGet, c.url)
+	if err != nil {
+		return checksum.Digest{}, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return checksum.Digest{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return checksum.Digest{}, fmt.Errorf("摘要文件 %s 不可用: %s", c.url, resp.Status)
+	}
+	return checksum.ParseSums(io.LimitReader(resp.Body, maxSidecarSize), c.algorithm, name)
+}
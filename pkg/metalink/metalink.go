@@ -0,0 +1,215 @@
+// pkg/metalink/metalink.go
+package metalink
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+)
+
+// Namespace 是 Metalink 4 文档根元素的 XML 命名空间
+const Namespace = "urn:ietf:params:xml:ns:metalink"
+
+// MediaType 是 Metalink 4 文档的 MIME 类型
+const MediaType = "application/metalink4+xml"
+
+// maxDocumentSize 限制了读取的文档大小，分块摘要很多的大文件的文档也远小于这个值
+const maxDocumentSize = 16 << 20
+
+// lowestPriority 是没有 priority 属性的地址的优先级，RFC 5854 中 priority 的取值范围是 1-999999
+const lowestPriority = 1000000
+
+// ErrUnsupportedScheme 表示 Metalink 文档的地址不是 http(s) URL
+var ErrUnsupportedScheme = errors.New("Metalink 文档的地址必须是 http 或 https URL")
+
+// preferredHashes 是选择摘要时的优先顺序，使用 Metalink 中的 IANA 算法名
+var preferredHashes = []string{"sha-512", "sha-256", "sha-1", "md5"}
+
+// Metalink 是一个 Metalink 4 (RFC 5854) 文档
+// 一个文档可以描述多个文件，每个文件带有多个下载地址、文件大小、整个文件的摘要以及分块摘要
+type Metalink struct {
+	Files []File `xml:"file"`
+}
+
+// File 描述了文档中的一个文件
+type File struct {
+	// Name 是文件的相对路径，可以包含子目录，例如 "linux/image.iso"
+	Name string `xml:"name,attr"`
+	// Size 是文件大小，文档中没有给出时为 0
+	Size   int64    `xml:"size"`
+	URLs   []URL    `xml:"url"`
+	Hashes []Hash   `xml:"hash"`
+	Pieces []Pieces `xml:"pieces"`
+}
+
+// URL 是文件的一个下载地址
+type URL struct {
+	URL      string `xml:",chardata"`
+	Priority int    `xml:"priority,attr"` // 越小越优先，没有时为 0，排在所有带优先级的地址之后
+	Location string `xml:"location,attr"` // ISO 3166-1 国家代码
+}
+
+// Hash 是整个文件的摘要，Type 是 IANA 算法名，例如 "sha-256"
+type Hash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Pieces 是文件按 Length 字节切分后每一块的摘要，最后一块可能不足 Length 字节
+type Pieces struct {
+	Type   string   `xml:"type,attr"`
+	Length int64    `xml:"length,attr"`
+	Hashes []string `xml:"hash"`
+}
+
+// Parse 从 r 中读取并解析 Metalink 4 文档
+// 文档中没有文件、文件名不安全 (绝对路径或包含 "..") 或者重名时返回错误
+func Parse(r io.Reader) (*Metalink, error) {
+	var doc struct {
+		XMLName xml.Name
+		Metalink
+	}
+	if err := xml.NewDecoder(io.LimitReader(r, maxDocumentSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("无法解析 Metalink 文档: %w", err)
+	}
+	if doc.XMLName.Space != Namespace || doc.XMLName.Local != "metalink" {
+		return nil, fmt.Errorf("不是 Metalink 4 文档 (根元素为 %s %s)，只支持 RFC 5854 格式", doc.XMLName.Space, doc.XMLName.Local)
+	}
+	m := &doc.Metalink
+	if len(m.Files) == 0 {
+		return nil, errors.New("Metalink 文档中没有文件")
+	}
+	seen := make(map[string]bool, len(m.Files))
+	for i := range m.Files {
+		f := &m.Files[i]
+		if !safeName(f.Name) {
+			return nil, fmt.Errorf("Metalink 中的文件名不安全: %q", f.Name)
+		}
+		if seen[f.Name] {
+			return nil, fmt.Errorf("Metalink 中的文件 %s 重复出现", f.Name)
+		}
+		seen[f.Name] = true
+		for j := range f.URLs {
+			f.URLs[j].URL = strings.TrimSpace(f.URLs[j].URL)
+		}
+	}
+	return m, nil
+}
+
+// LoadURL 使用 c 下载 rawURL 指向的 Metalink 文档，c 为 nil 时使用 http.DefaultClient
+// 只接受 http 和 https 地址，其他协议 (包括本地文件路径) 返回 ErrUnsupportedScheme
+func LoadURL(ctx context.Context, c *http.Client, rawURL string) (*Metalink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrUnsupportedScheme
+	}
+
+	if c == nil {
+		c = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", MediaType+", application/xml;q=0.9, */*;q=0.1")
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("无法下载 Metalink 文档: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("无法下载 Metalink 文档: 服务器返回 %s", resp.Status)
+	}
+	return Parse(resp.Body)
+}
+
+// HTTPURLs 返回文件的 http 和 https 下载地址，按优先级从高到低排列
+// 其他协议 (ftp、magnet 等) 的地址会被忽略
+func (f *File) HTTPURLs() []string {
+	urls := make([]URL, 0, len(f.URLs))
+	for _, u := range f.URLs {
+		if p, err := url.Parse(u.URL); err == nil && (p.Scheme == "http" || p.Scheme == "https") && p.Host != "" {
+			urls = append(urls, u)
+		}
+	}
+	sort.SliceStable(urls, func(i, j int) bool { return priority(urls[i]) < priority(urls[j]) })
+	out := make([]string, len(urls))
+	for i, u := range urls {
+		out[i] = u.URL
+	}
+	return out
+}
+
+// Checksum 返回整个文件的摘要，有多个摘要时选择最强的受支持算法，没有受支持的摘要时返回零值
+func (f *File) Checksum() (checksum.Digest, error) {
+	for _, algo := range preferredHashes {
+		for _, h := range f.Hashes {
+			if strings.EqualFold(strings.TrimSpace(h.Type), algo) {
+				d, err := checksum.New(algo, h.Value)
+				if err != nil {
+					return checksum.Digest{}, fmt.Errorf("文件 %s 的摘要无效: %w", f.Name, err)
+				}
+				return d, nil
+			}
+		}
+	}
+	return checksum.Digest{}, nil
+}
+
+// PieceChecksums 返回分块的长度和每一块的摘要，有多组分块摘要时选择最强的受支持算法
+// 没有受支持的分块摘要时返回 0 和 nil
+func (f *File) PieceChecksums() (int64, []checksum.Digest, error) {
+	for _, algo := range preferredHashes {
+		for _, p := range f.Pieces {
+			if !strings.EqualFold(strings.TrimSpace(p.Type), algo) {
+				continue
+			}
+			if p.Length <= 0 || len(p.Hashes) == 0 {
+				return 0, nil, fmt.Errorf("文件 %s 的分块摘要无效", f.Name)
+			}
+			if f.Size > 0 && int64(len(p.Hashes)) != (f.Size+p.Length-1)/p.Length {
+				return 0, nil, fmt.Errorf("文件 %s 的分块数 %d 与文件大小 %d 不符", f.Name, len(p.Hashes), f.Size)
+			}
+			digests := make([]checksum.Digest, len(p.Hashes))
+			for i, h := range p.Hashes {
+				d, err := checksum.New(algo, h)
+				if err != nil {
+					return 0, nil, fmt.Errorf("文件 %s 的第 %d 块摘要无效: %w", f.Name, i, err)
+				}
+				digests[i] = d
+			}
+			return p.Length, digests, nil
+		}
+	}
+	return 0, nil, nil
+}
+
+// priority 返回地址的优先级，没有设置时排在最后
+func priority(u URL) int {
+	if u.Priority <= 0 {
+		return lowestPriority
+	}
+	return u.Priority
+}
+
+// safeName 判断文件名是否可以安全地作为相对路径使用 (RFC 5854 4.1.2.1)
+func safeName(name string) bool {
+	if name == "" || strings.ContainsAny(name, "\\\x00") || path.IsAbs(name) {
+		return false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
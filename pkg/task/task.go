@@ -1,6 +1,15 @@
 package task
 
-import "github.com/google/uuid"
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Slade66/parallel-fetcher/internal/redact"
+	"github.com/Slade66/parallel-fetcher/pkg/checksum"
+	"github.com/google/uuid"
+)
 
 // DownloadTask 定义了一个完整的分布式下载任务，它将作为消息在 Redis Stream 中传递。
 type DownloadTask struct {
@@ -10,11 +19,168 @@ type DownloadTask struct {
 	// 要下载的文件的完整 URL。
 	URL string `json:"url"`
 
+	// 与 URL 内容相同的其他下载地址 (镜像)。文件大小与 URL 一致的镜像会和 URL 一起分担分片，
+	// 速度快的地址分到更多分片，出错或过慢的镜像会被放弃，最终仍然只生成一个文件。
+	Mirrors []string `json:"mirrors,omitempty"`
+
 	// 文件的保存路径，应包含完整路径和最终的文件名。
 	// 例如: "/downloads/videos/my_video.mp4"
 	OutputPath string `json:"output_path"`
 
+	// 上传到 OBS 时使用的对象键，可以包含目录，例如 "linux/image.iso"。
+	// 为空时使用 OutputPath 中的文件名。
+	ObjectKey string `json:"object_key,omitempty"`
+
 	// 建议下载时使用的线程数。
 	// Worker 服务可以将其作为参考。
 	Threads int `json:"threads"`
+
+	// 单个分片失败后的最大重试次数。
+	// 为 0 时使用 Worker 的默认值，小于 0 表示不重试。
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	// 第一次重试前的等待时间（毫秒），之后每次重试翻倍并加入随机抖动。
+	// 为 0 时使用 Worker 的默认值。
+	RetryBackoffMs int `json:"retry_backoff_ms,omitempty"`
+
+	// 两次重试之间的最长等待时间（毫秒），为 0 时使用 Worker 的默认值。
+	RetryMaxBackoffMs int `json:"retry_max_backoff_ms,omitempty"`
+
+	// 分片大小的范围（字节）。文件会被切分为许多大小在该范围内的分片，
+	// 由 Threads 个连接领取下载。为 0 时使用下载器的默认值。
+	MinChunkSize int64 `json:"min_chunk_size,omitempty"`
+	MaxChunkSize int64 `json:"max_chunk_size,omitempty"`
+
+	// 分片数据的落盘方式："parts" (默认) 每个分片写入独立文件，完成后合并；
+	// "direct" 预分配最终文件，各分片直接写入各自的偏移位置，省去合并。
+	WriteMode string `json:"write_mode,omitempty"`
+
+	// 任务的截止时长（秒），超时后下载会被中止并清理临时文件。
+	// 为 0 时使用 Worker 的默认值。
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	// 停滞检测窗口（秒）：连接超过该时间没有收到任何数据时中止并重试该分片。
+	// 为 0 时使用 Worker 的默认值，小于 0 表示不检测。
+	StallTimeoutSeconds int `json:"stall_timeout_seconds,omitempty"`
+
+	// 文件的期望摘要，格式为 "算法:摘要"，例如 "sha256:9f86d08..."。
+	// 支持 sha256、sha512、sha1、md5 和 crc32c，摘要可以是十六进制或 base64 编码。
+	// 下载完成后会进行校验，不一致的文件不会被上传。
+	Checksum string `json:"checksum,omitempty"`
+
+	// 没有提供 Checksum 且服务器的响应头中也没有摘要时，是否探测与文件一起发布的摘要文件
+	// (<文件>.sha256、同目录下的 SHA256SUMS 等)
+	ProbeSidecar bool `json:"probe_sidecar,omitempty"`
+
+	// 文件的期望大小 (字节)，通常来自 Metalink 的 <size>，为 0 时不检查。
+	// 原始地址报告的大小与之不同时任务失败，镜像的大小必须与原始地址一致。
+	Size int64 `json:"size,omitempty"`
+
+	// 文件按 PieceLength 字节切分后每一块的期望摘要，格式与 Checksum 相同，通常来自 Metalink 的 <pieces>。
+	// 下载完成后逐块校验，只重新下载校验失败的块。
+	PieceLength    int64    `json:"piece_length,omitempty"`
+	PieceChecksums []string `json:"piece_checksums,omitempty"`
+
+	// 本任务的下载速度上限，例如 "20M" 表示每秒 20 MiB，为空表示不限速。
+	// Worker 进程还可能设置了所有任务共享的速度上限，两者同时生效。
+	RateLimit string `json:"rate_limit,omitempty"`
+
+	// 是否根据实际吞吐量自动调整连接数。开启后从少量连接开始逐步增加，
+	// Threads 作为连接数的上限 (未设置时使用 Worker 允许的最大值)
+	AdaptiveThreads bool `json:"adaptive_threads,omitempty"`
+
+	// 探测和下载每个分片时附带的请求头，例如 {"User-Agent": "...", "X-Api-Key": "..."}。
+	// 不能设置 Range 和 If-Range，它们由下载器管理。
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Cookie 请求头的值，例如 "session=abc; theme=dark"。
+	Cookies string `json:"cookies,omitempty"`
+
+	// 以 "Authorization: Bearer <令牌>" 发送的令牌，不能与 Username 同时设置。
+	BearerToken string `json:"bearer_token,omitempty"`
+
+	// HTTP Basic 认证的用户名和密码。
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// 注意: 以上凭据会随任务消息明文保存在 Redis Stream 中，但不会出现在任务状态和日志里。
+
+	// Worker 上配置的传输配置名 (代理、证书、HTTP/2 等)，为空时使用 "default" 配置或内置的默认值。
+	TransportProfile string `json:"transport_profile,omitempty"`
+}
+
+// RequestHeader 根据任务中的请求头和凭据生成每个请求都要附带的请求头，没有任何请求头时返回 nil
+func (t *DownloadTask) RequestHeader() (http.Header, error) {
+	h := make(http.Header)
+	for k, v := range t.Headers {
+		if !validHeaderName(k) {
+			return nil, fmt.Errorf("无效的请求头名称: %q", k)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("请求头 %s 的值不能包含换行符", k)
+		}
+		switch http.CanonicalHeaderKey(k) {
+		case "Range", "If-Range":
+			return nil, fmt.Errorf("不能自定义请求头 %s", k)
+		}
+		h.Set(k, v)
+	}
+	if t.Cookies != "" {
+		h.Set("Cookie", t.Cookies)
+	}
+	switch {
+	case t.BearerToken != "" && t.Username != "":
+		return nil, fmt.Errorf("bearer_token 和 username 不能同时设置")
+	case t.BearerToken != "":
+		h.Set("Authorization", "Bearer "+t.BearerToken)
+	case t.Username != "":
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(t.Username+":"+t.Password)))
+	}
+	if len(h) == 0 {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// Pieces 解析分块摘要，任务没有分块摘要时返回 0 和 nil
+func (t *DownloadTask) Pieces() (int64, []checksum.Digest, error) {
+	if len(t.PieceChecksums) == 0 {
+		return 0, nil, nil
+	}
+	if t.PieceLength <= 0 {
+		return 0, nil, fmt.Errorf("设置了 piece_checksums 时 piece_length 必须大于 0")
+	}
+	digests := make([]checksum.Digest, len(t.PieceChecksums))
+	for i, s := range t.PieceChecksums {
+		d, err := checksum.Parse(s)
+		if err != nil {
+			return 0, nil, fmt.Errorf("第 %d 块的摘要无效: %w", i, err)
+		}
+		digests[i] = d
+	}
+	return t.PieceLength, digests, nil
+}
+
+// Secrets 返回任务中的凭据，包括疑似凭据的自定义请求头的值，用于从错误信息和日志中隐藏它们
+func (t *DownloadTask) Secrets() []string {
+	secrets := []string{t.BearerToken, t.Password, t.Cookies}
+	for k, v := range t.Headers {
+		if redact.SensitiveName(k) {
+			secrets = append(secrets, v)
+		}
+	}
+	return secrets
+}
+
+// validHeaderName 判断 name 是否是合法的 HTTP 请求头名称 (RFC 9110 中的 token)
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
 }